@@ -4,6 +4,7 @@ import "database/sql"
 import "database/sql/driver"
 import _ "go-mysql-binlog/mysql"
 import "fmt"
+import "io"
 
 const dataSource = "root@tcp(127.0.0.1:3306)/shopify_dev"
 
@@ -33,8 +34,7 @@ func main() {
 	}
 	fmt.Printf("filename: %v, position: %v\n", filename, position)
 
-	driver := db.Driver()
-	conn, err := driver.Open(dataSource)
+	conn, err := db.Driver().Open(dataSource)
 	if err != nil {
 		panic(err)
 	}
@@ -44,7 +44,22 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	if rows != nil {
-		fmt.Println("Got results from binlog")
+	defer rows.Close()
+	fmt.Println(rows.Columns())
+	values := make([]driver.Value, len(rows.Columns()))
+	for {
+		if err = rows.Next(values); err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		// The row images are JSON
+		for _, value := range values {
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			fmt.Print(value, " ")
+		}
+		fmt.Println()
 	}
 }
@@ -1,14 +1,24 @@
 package mysql
 
 import (
+	"context"
 	"database/sql/driver"
 	"encoding/binary"
+	"encoding/json"
 	"bytes"
 	"io"
 
 	"fmt"
+	"os"
 	"time"
 	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 type Bitfield []byte
@@ -21,11 +31,22 @@ func (bits Bitfield) isSet(index uint) bool {
 	return bits[index / 8] & (1 << (index % 8)) != 0
 }
 
+// Returns the number of set bits among the first size bits
+func (bits Bitfield) count(size int) (n int) {
+	for i := 0; i < size; i++ {
+		if bits.isSet(uint(i)) {
+			n++
+		}
+	}
+	return
+}
 
-type eventType byte
+
+// EventType is the type code of a binlog event, as found in its header
+type EventType byte
 
 const (
-	UNKNOWN_EVENT eventType = iota
+	UNKNOWN_EVENT EventType = iota
 	START_EVENT_V3
 	QUERY_EVENT
 	STOP_EVENT
@@ -61,13 +82,35 @@ const (
 	GTID_EVENT
 	ANONYMOUS_GTID_EVENT
 	PREVIOUS_GTIDS_EVENT
+	TRANSACTION_CONTEXT_EVENT
+	VIEW_CHANGE_EVENT
+	XA_PREPARE_LOG_EVENT
+	PARTIAL_UPDATE_ROWS_EVENT
+	TRANSACTION_PAYLOAD_EVENT
+)
+
+// Event types only MariaDB logs, parsed with FLAVOR_MARIADB
+const (
+	MARIADB_ANNOTATE_ROWS_EVENT EventType = 160 + iota
+	MARIADB_BINLOG_CHECKPOINT_EVENT
+	MARIADB_GTID_EVENT
+	MARIADB_GTID_LIST_EVENT
+	MARIADB_START_ENCRYPTION_EVENT
+	MARIADB_QUERY_COMPRESSED_EVENT
+	MARIADB_WRITE_ROWS_COMPRESSED_EVENTv1
+	MARIADB_UPDATE_ROWS_COMPRESSED_EVENTv1
+	MARIADB_DELETE_ROWS_COMPRESSED_EVENTv1
+	MARIADB_WRITE_ROWS_COMPRESSED_EVENT
+	MARIADB_UPDATE_ROWS_COMPRESSED_EVENT
+	MARIADB_DELETE_ROWS_COMPRESSED_EVENT
 )
 
 
-type eventFlag uint16
+// EventFlag is a bit of the flags of an event header
+type EventFlag uint16
 
 const (
-	LOG_EVENT_BINLOG_IN_USE_F eventFlag = 1 << iota
+	LOG_EVENT_BINLOG_IN_USE_F EventFlag = 1 << iota
 	LOG_EVENT_FORCED_ROTATE_F
 	LOG_EVENT_THREAD_SPECIFIC_F
 	LOG_EVENT_SUPPRESS_USE_F
@@ -81,11 +124,11 @@ const (
 
 type EventHeader struct {
 	Timestamp uint32
-	EventType eventType
+	EventType EventType
 	ServerId uint32
 	EventSize uint32
 	LogPos uint32
-	Flags eventFlag
+	Flags EventFlag
 }
 
 
@@ -106,8 +149,20 @@ func (event *GenericEvent) Header() (*EventHeader) {
 }
 
 func (event *GenericEvent) Print() {
-	event.header.Print()
-	fmt.Printf("Event Data:\n%s\n\n", hex.Dump(event.data))
+	event.PrintTo(os.Stdout)
+}
+
+func (event *GenericEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "Event Data:\n%s\n\n", hex.Dump(event.data))
+}
+
+// MarshalJSON encodes the event as its header and its undecoded data, in
+// base64.
+func (event *GenericEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"data": event.data,
+	})
 }
 
 
@@ -119,8 +174,12 @@ type RotateEvent struct {
 
 func parseRotateEvent(buf *bytes.Buffer) (event *RotateEvent, err error) {
 	event = new(RotateEvent)
-	err = binary.Read(buf, binary.LittleEndian, &event.header)
-	err = binary.Read(buf, binary.LittleEndian, &event.position)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &event.position); err != nil {
+		return
+	}
 	event.filename = buf.String()
 	return
 }
@@ -129,313 +188,1939 @@ func (event *RotateEvent) Header() (*EventHeader) {
 	return &event.header
 }
 
+// Filename returns the name of the binlog file the following events are in
+func (event *RotateEvent) Filename() (string) {
+	return event.filename
+}
+
+// Position returns the position of the next event in that file
+func (event *RotateEvent) Position() (uint64) {
+	return event.position
+}
+
+// Artificial tells whether the master made up the event rather than reading it
+// from the binlog, as it does at the start of every dump to tell the file name.
+// Artificial rotations don't end a file and have a LogPos of 0, and their
+// Position isn't where a GTID dump continues, so it must not be persisted as a
+// position to resume from. Reader.Position and DumpBinlogReconnect ignore them,
+// except for the file name of streams started without one.
+func (event *RotateEvent) Artificial() (bool) {
+	return event.header.Flags & LOG_EVENT_ARTIFICIAL_F != 0
+}
+
 func (event *RotateEvent) Print() {
-	event.header.Print()
-	fmt.Printf("position: %v, filename: %#v\n", event.position, event.filename)
+	event.PrintTo(os.Stdout)
 }
 
+func (event *RotateEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "position: %v, filename: %#v\n", event.position, event.filename)
+}
 
-type QueryEvent struct {
-	header EventHeader
-	slaveProxyId uint32
-	executionTime uint32
-	errorCode uint16
-	schema string
-	statusVars string
-	query string
+func (event *RotateEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"position": event.position,
+		"filename": event.filename,
+		"artificial": event.Artificial(),
+	})
 }
 
-func parseQueryEvent(buf *bytes.Buffer) (event *QueryEvent, err error) {
-	var schemaLength byte
-	var statusVarsLength uint16
 
-	event = new(QueryEvent)
-	err = binary.Read(buf, binary.LittleEndian, &event.header)
-	err = binary.Read(buf, binary.LittleEndian, &event.slaveProxyId)
-	err = binary.Read(buf, binary.LittleEndian, &event.executionTime)
-	err = binary.Read(buf, binary.LittleEndian, &schemaLength)
-	err = binary.Read(buf, binary.LittleEndian, &event.errorCode)
-	err = binary.Read(buf, binary.LittleEndian, &statusVarsLength)
-	event.statusVars = string(buf.Next(int(statusVarsLength)))
-	event.schema = string(buf.Next(int(schemaLength)))
-	_, err = buf.ReadByte()
-	event.query = buf.String()
+// Sent by the master during a binlog dump when it has had no new events for
+// the heartbeat period, see SetHeartbeat. It is never written to binlog files.
+type HeartbeatEvent struct {
+	header EventHeader
+	filename string
+}
+
+/* Heartbeat Event
+Bytes                        Name
+-----                        ----
+n                            name of the master's current binlog file, up to
+                             the end of the event
+The position in that file is the header's LogPos.
+*/
+func parseHeartbeatEvent(buf *bytes.Buffer) (event *HeartbeatEvent, err error) {
+	event = new(HeartbeatEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	event.filename = buf.String()
 	return
 }
 
-func (event *QueryEvent) Header() (*EventHeader) {
+func (event *HeartbeatEvent) Header() (*EventHeader) {
 	return &event.header
 }
 
-func (event *QueryEvent) Print() {
-	event.header.Print()
-	fmt.Printf("slaveProxyId: %v, executionTime: %v, errorCode: %v, schema: %v, statusVars: %#v, query: %#v\n",
-	           event.slaveProxyId, event.executionTime, event.errorCode, event.schema, event.statusVars, event.query)
+// Filename returns the name of the binlog file the master is at
+func (event *HeartbeatEvent) Filename() (string) {
+	return event.filename
+}
+
+// Position returns the position the master is at in that file
+func (event *HeartbeatEvent) Position() (uint32) {
+	return event.header.LogPos
 }
 
+func (event *HeartbeatEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
 
-type FormatDescriptionEvent struct {
+func (event *HeartbeatEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "filename: %#v\n", event.filename)
+}
+
+func (event *HeartbeatEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"filename": event.filename,
+	})
+}
+
+
+type XIDEvent struct {
 	header EventHeader
-	binlogVersion uint16
-	mysqlServerVersion string
-	createTimestamp uint32
-	eventHeaderLength uint8
-	eventTypeHeaderLengths []uint8
+	xid uint64
 }
 
-func parseFormatDescriptionEvent(buf *bytes.Buffer) (event *FormatDescriptionEvent, err error) {
-	event = new(FormatDescriptionEvent)
-	err = binary.Read(buf, binary.LittleEndian, &event.header)
-	err = binary.Read(buf, binary.LittleEndian, &event.binlogVersion)
-	event.mysqlServerVersion = string(buf.Next(50))
-	err = binary.Read(buf, binary.LittleEndian, &event.createTimestamp)
-	event.eventHeaderLength, err = buf.ReadByte()
-	event.eventTypeHeaderLengths = buf.Bytes()
+func parseXIDEvent(buf *bytes.Buffer) (event *XIDEvent, err error) {
+	event = new(XIDEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	err = binary.Read(buf, binary.LittleEndian, &event.xid)
 	return
 }
 
-func (event *FormatDescriptionEvent) Header() (*EventHeader) {
+func (event *XIDEvent) Header() (*EventHeader) {
 	return &event.header
 }
 
-func (event *FormatDescriptionEvent) Print() {
-	event.header.Print()
-	fmt.Printf("binlogVersion: %v, mysqlServerVersion: %v, createTimestamp: %v, eventHeaderLength: %v, eventTypeHeaderLengths: %#v\n",
-	           event.binlogVersion, event.mysqlServerVersion, event.createTimestamp, event.eventHeaderLength, event.eventTypeHeaderLengths)
+// Xid returns the id of the transaction this event commits
+func (event *XIDEvent) Xid() (uint64) {
+	return event.xid
 }
 
+func (event *XIDEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
 
-type RowsEvent struct {
-	header EventHeader
-	tableId uint64
-	tableMap *TableMapEvent
-	flags uint16
-	columnsPresentBitmap1 Bitfield
-	columnsPresentBitmap2 Bitfield
-	rows []*[]driver.Value
+func (event *XIDEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "xid: %v\n", event.xid)
 }
 
-func parseEventRow(buf *bytes.Buffer, tableMap *TableMapEvent) (row []driver.Value, e error) {
-	columnsCount := len(tableMap.columnTypes)
+func (event *XIDEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"xid": event.xid,
+	})
+}
 
-	row = make([]driver.Value, columnsCount)
 
-	bitfieldSize := (columnsCount + 7) / 8
-	nullBitMap := Bitfield(buf.Next(bitfieldSize))
+const (
+	INVALID_INT_EVENT uint8 = iota
+	LAST_INSERT_ID_EVENT
+	INSERT_ID_EVENT
+)
 
-	for i := 0; i < columnsCount; i++ {
-		if nullBitMap.isSet(uint(i)) {
-			row[i] = nil
-			continue
-		}
+type IntVarEvent struct {
+	header EventHeader
+	varType uint8
+	value uint64
+}
 
-		switch tableMap.columnTypes[i] {
-		case FIELD_TYPE_NULL:
-			row[i] = nil
+func parseIntVarEvent(buf *bytes.Buffer) (event *IntVarEvent, err error) {
+	event = new(IntVarEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	if event.varType, err = buf.ReadByte(); err != nil {
+		return
+	}
+	err = binary.Read(buf, binary.LittleEndian, &event.value)
+	return
+}
 
-		case FIELD_TYPE_TINY:
-			var b byte
-			b, e = buf.ReadByte()
-			row[i] = int64(b)
+func (event *IntVarEvent) Header() (*EventHeader) {
+	return &event.header
+}
 
-		case FIELD_TYPE_SHORT:
-			var short int16
-			e = binary.Read(buf, binary.LittleEndian, &short)
-			row[i] = int64(short)
+// Type returns INSERT_ID_EVENT if Value is the AUTO_INCREMENT value used by
+// the next query, or LAST_INSERT_ID_EVENT if it's the LAST_INSERT_ID() value.
+func (event *IntVarEvent) Type() (uint8) {
+	return event.varType
+}
 
-		case FIELD_TYPE_YEAR:
-			var b byte
-			b, e = buf.ReadByte()
-			if e == nil && b != 0 {
-				row[i] = time.Date(int(b) + 1900, time.January, 0, 0, 0, 0, 0, time.UTC)
-			}
+func (event *IntVarEvent) Value() (uint64) {
+	return event.value
+}
 
-		case FIELD_TYPE_INT24:
-			row[i], e = readFixedLengthInteger(buf, 3)
+func (event *IntVarEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
 
-		case FIELD_TYPE_LONG:
-			var long int32
-			e = binary.Read(buf, binary.LittleEndian, &long)
-			row[i] = int64(long)
+func (event *IntVarEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "type: %s, value: %v\n", event.typeName(), event.value)
+}
 
-		case FIELD_TYPE_LONGLONG:
-			var longlong int64
-			e = binary.Read(buf, binary.LittleEndian, &longlong)
-			row[i] = longlong
+func (event *IntVarEvent) typeName() string {
+	switch event.varType {
+	case LAST_INSERT_ID_EVENT:
+		return "LAST_INSERT_ID"
+	case INSERT_ID_EVENT:
+		return "INSERT_ID"
+	}
+	return fmt.Sprintf("%d", event.varType)
+}
 
-		case FIELD_TYPE_FLOAT:
-			var float float32
-			e = binary.Read(buf, binary.LittleEndian, &float)
-			row[i] = float64(float)
+func (event *IntVarEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"type": event.typeName(),
+		"value": event.value,
+	})
+}
 
-		case FIELD_TYPE_DOUBLE:
-			var double float64
-			e = binary.Read(buf, binary.LittleEndian, &double)
-			row[i] = double
 
-		case FIELD_TYPE_DECIMAL, FIELD_TYPE_NEWDECIMAL:
-			return nil, fmt.Errorf("parseEventRow unimplemented for field type %s", fieldTypeName(tableMap.columnTypes[i]))
+type QueryEvent struct {
+	header EventHeader
+	slaveProxyId uint32
+	executionTime uint32
+	errorCode uint16
+	schema string
+	statusVars string
+	query string
+}
 
-		case FIELD_TYPE_VARCHAR:
-			max_length := tableMap.columnMeta[i]
-			var length int
-			if max_length > 255 {
-				var short uint16
-				e = binary.Read(buf, binary.LittleEndian, &short)
-				length = int(short)
-			} else {
-				var b byte
-				b, e = buf.ReadByte()
-				length = int(b)
-			}
-			if buf.Len() < length {
-				e = io.EOF
-			}
-			row[i] = string(buf.Next(length))
+func parseQueryEvent(buf *bytes.Buffer) (event *QueryEvent, err error) {
+	event = new(QueryEvent)
+	err = event.read(buf, nil)
+	return
+}
 
-		case FIELD_TYPE_BLOB:
-			var length uint64
-			length, e = readFixedLengthInteger(buf, int(tableMap.columnMeta[i]))
-			row[i] = string(buf.Next(int(length)))
+// Reads a query event. Event types derived from it extend the post header,
+// their extra fields are read into extraPostHeader.
+func (event *QueryEvent) read(buf *bytes.Buffer, extraPostHeader interface{}) (err error) {
+	var schemaLength byte
+	var statusVarsLength uint16
 
-		case FIELD_TYPE_BIT, FIELD_TYPE_ENUM,
-			FIELD_TYPE_SET, FIELD_TYPE_TINY_BLOB, FIELD_TYPE_MEDIUM_BLOB,
-			FIELD_TYPE_LONG_BLOB, FIELD_TYPE_VAR_STRING,
-			FIELD_TYPE_STRING, FIELD_TYPE_GEOMETRY:
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &event.slaveProxyId); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &event.executionTime); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &schemaLength); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &event.errorCode); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &statusVarsLength); err != nil {
+		return
+	}
+	if extraPostHeader != nil {
+		if err = binary.Read(buf, binary.LittleEndian, extraPostHeader); err != nil {
+			return
+		}
+	}
+	var data []byte
+	if data, err = readBytes(buf, int(statusVarsLength)); err != nil {
+		return
+	}
+	event.statusVars = string(data)
+	if data, err = readBytes(buf, int(schemaLength)); err != nil {
+		return
+	}
+	event.schema = string(data)
+	if _, err = buf.ReadByte(); err != nil {
+		return
+	}
+	event.query = buf.String()
+	return
+}
 
-			return nil, fmt.Errorf("parseEventRow unimplemented for field type %s", fieldTypeName(tableMap.columnTypes[i]))
+func (event *QueryEvent) Header() (*EventHeader) {
+	return &event.header
+}
 
-		case FIELD_TYPE_DATE, FIELD_TYPE_NEWDATE:
-			return nil, fmt.Errorf("parseEventRow unimplemented for field type %s", fieldTypeName(tableMap.columnTypes[i]))
+func (event *QueryEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
 
-		case FIELD_TYPE_TIME:
-			return nil, fmt.Errorf("parseEventRow unimplemented for field type %s", fieldTypeName(tableMap.columnTypes[i]))
+func (event *QueryEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "slaveProxyId: %v, executionTime: %v, errorCode: %v, schema: %v, statusVars: %#v, query: %#v\n",
+	            event.slaveProxyId, event.executionTime, event.errorCode, event.schema, event.statusVars, event.query)
+}
 
-		case FIELD_TYPE_TIMESTAMP:
-			return nil, fmt.Errorf("parseEventRow unimplemented for field type %s", fieldTypeName(tableMap.columnTypes[i]))
-		case FIELD_TYPE_DATETIME:
-			var t int64
-			e = binary.Read(buf, binary.LittleEndian, &t)
+func (event *QueryEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, event.jsonFields())
+}
 
-			second := int(t % 100)
-			minute := int((t % 10000) / 100)
-			hour := int((t % 1000000) / 10000)
+// Status variables are left out, see StatusVars
+func (event *QueryEvent) jsonFields() map[string]interface{} {
+	return map[string]interface{}{
+		"slaveProxyId": event.slaveProxyId,
+		"executionTime": event.executionTime,
+		"errorCode": event.errorCode,
+		"schema": event.schema,
+		"query": event.query,
+	}
+}
 
-			d := int(t / 1000000)
-			day := d % 100
-			month := time.Month((d % 10000) / 100)
-			year := d / 10000
 
-			row[i] = time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+// Query event status variable codes
+const (
+	Q_FLAGS2_CODE uint8 = iota
+	Q_SQL_MODE_CODE
+	Q_CATALOG_CODE
+	Q_AUTO_INCREMENT
+	Q_CHARSET_CODE
+	Q_TIME_ZONE_CODE
+	Q_CATALOG_NZ_CODE
+	Q_LC_TIME_NAMES_CODE
+	Q_CHARSET_DATABASE_CODE
+	Q_TABLE_MAP_FOR_UPDATE_CODE
+	Q_MASTER_DATA_WRITTEN_CODE
+	Q_INVOKER
+	Q_UPDATED_DB_NAMES
+	Q_MICROSECONDS
+	Q_COMMIT_TS
+	Q_COMMIT_TS2
+	Q_EXPLICIT_DEFAULTS_FOR_TIMESTAMP
+	Q_DDL_LOGGED_WITH_XID
+	Q_DEFAULT_COLLATION_FOR_UTF8MB4
+	Q_SQL_REQUIRE_PRIMARY_KEY
+	Q_DEFAULT_TABLE_ENCRYPTION
+)
 
+// Q_UPDATED_DB_NAMES count meaning the database names weren't logged
+const OVER_MAX_DBS_IN_EVENT_MTS = 254
+
+// StatusVars decodes the status variables of the query, keyed by their Q_*
+// code. The values are:
+//	Q_FLAGS2_CODE, Q_MASTER_DATA_WRITTEN_CODE, Q_MICROSECONDS         uint32
+//	Q_SQL_MODE_CODE, Q_TABLE_MAP_FOR_UPDATE_CODE, Q_DDL_LOGGED_WITH_XID uint64
+//	Q_CATALOG_CODE, Q_CATALOG_NZ_CODE, Q_TIME_ZONE_CODE                string
+//	Q_AUTO_INCREMENT                   []uint16{increment, offset}
+//	Q_CHARSET_CODE                     []uint16{client charset, connection collation, server collation}
+//	Q_LC_TIME_NAMES_CODE, Q_CHARSET_DATABASE_CODE,
+//	Q_DEFAULT_COLLATION_FOR_UTF8MB4    uint16
+//	Q_INVOKER                          []string{user, host}
+//	Q_UPDATED_DB_NAMES                 []string, nil if there were too many
+//	other known codes                  uint8
+// Since the status variables aren't length-prefixed, decoding stops with an
+// error at the first unknown code; the variables before it are returned.
+func (event *QueryEvent) StatusVars() (vars map[uint8]interface{}, e error) {
+	vars = make(map[uint8]interface{})
+	buf := bytes.NewBufferString(event.statusVars)
+	for buf.Len() > 0 {
+		code, _ := buf.ReadByte()
+		var value interface{}
+		switch code {
+		case Q_FLAGS2_CODE, Q_MASTER_DATA_WRITTEN_CODE:
+			var n uint64
+			n, e = readFixedLengthInteger(buf, 4)
+			value = uint32(n)
+		case Q_MICROSECONDS:
+			var n uint64
+			n, e = readFixedLengthInteger(buf, 3)
+			value = uint32(n)
+		case Q_SQL_MODE_CODE, Q_TABLE_MAP_FOR_UPDATE_CODE, Q_DDL_LOGGED_WITH_XID:
+			value, e = readFixedLengthInteger(buf, 8)
+		case Q_CATALOG_CODE:
+			value, e = readStatusVarString(buf)
+			if e == nil {
+				_, e = buf.ReadByte()
+			}
+		case Q_CATALOG_NZ_CODE, Q_TIME_ZONE_CODE:
+			value, e = readStatusVarString(buf)
+		case Q_AUTO_INCREMENT, Q_CHARSET_CODE:
+			count := 2
+			if code == Q_CHARSET_CODE {
+				count = 3
+			}
+			values := make([]uint16, count)
+			e = binary.Read(buf, binary.LittleEndian, values)
+			value = values
+		case Q_LC_TIME_NAMES_CODE, Q_CHARSET_DATABASE_CODE, Q_DEFAULT_COLLATION_FOR_UTF8MB4:
+			var n uint16
+			e = binary.Read(buf, binary.LittleEndian, &n)
+			value = n
+		case Q_INVOKER:
+			var user, host string
+			if user, e = readStatusVarString(buf); e == nil {
+				host, e = readStatusVarString(buf)
+			}
+			value = []string{user, host}
+		case Q_UPDATED_DB_NAMES:
+			var count byte
+			if count, e = buf.ReadByte(); e != nil {
+				break
+			}
+			var names []string
+			for i := 0; i < int(count) && count != OVER_MAX_DBS_IN_EVENT_MTS; i++ {
+				var name []byte
+				if name, e = buf.ReadBytes(0); e != nil {
+					break
+				}
+				names = append(names, string(name[:len(name) - 1]))
+			}
+			value = names
+		case Q_EXPLICIT_DEFAULTS_FOR_TIMESTAMP, Q_SQL_REQUIRE_PRIMARY_KEY, Q_DEFAULT_TABLE_ENCRYPTION:
+			value, e = buf.ReadByte()
 		default:
-			return nil, fmt.Errorf("Unknown FieldType %d", tableMap.columnTypes[i])
+			return vars, fmt.Errorf("Unknown query event status variable code %d", code)
 		}
 		if e != nil {
-			return nil, e
+			return vars, truncatedEventError(event.header.EventType, e)
 		}
+		vars[code] = value
 	}
-	return
+	return vars, nil
 }
 
-func (parser *eventParser) parseRowsEvent(buf *bytes.Buffer) (event *RowsEvent, err error) {
-	var columnCount uint64
+// Reads a string with a 1-byte length prefix
+func readStatusVarString(buf *bytes.Buffer) (string, error) {
+	length, e := buf.ReadByte()
+	if e != nil {
+		return "", e
+	}
+	value, e := readBytes(buf, int(length))
+	return string(value), e
+}
 
-	event = new(RowsEvent)
-	err = binary.Read(buf, binary.LittleEndian, &event.header)
 
-	headerSize := parser.format.eventTypeHeaderLengths[event.header.EventType - 1]
-	var tableIdSize int
-	if headerSize == 6 {
-		tableIdSize = 4
-	} else {
-		tableIdSize = 6
-	}
-	event.tableId, err = readFixedLengthInteger(buf, tableIdSize)
+// How LOAD DATA handles rows that duplicate an existing unique key
+type LoadDupHandling uint8
 
-	err = binary.Read(buf, binary.LittleEndian, &event.flags)
-	columnCount, _, err = readLengthEncodedInt(buf)
+const (
+	LOAD_DUP_ERROR LoadDupHandling = iota
+	LOAD_DUP_IGNORE
+	LOAD_DUP_REPLACE
+)
 
-	event.columnsPresentBitmap1 = Bitfield(buf.Next(int((columnCount + 7) / 8)))
-	switch event.header.EventType {
-	case UPDATE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv2:
-		event.columnsPresentBitmap2 = Bitfield(buf.Next(int((columnCount + 7) / 8)))
+func (dup LoadDupHandling) String() string {
+	switch dup {
+	case LOAD_DUP_ERROR:
+		return "LOAD_DUP_ERROR"
+	case LOAD_DUP_IGNORE:
+		return "LOAD_DUP_IGNORE"
+	case LOAD_DUP_REPLACE:
+		return "LOAD_DUP_REPLACE"
 	}
+	return fmt.Sprintf("%d", uint8(dup))
+}
 
-	event.tableMap = parser.tableMap[event.tableId]
-	for buf.Len() > 0 {
-		var row []driver.Value
-		row, err = parseEventRow(buf, event.tableMap)
-		if err != nil {
-			return
-		}
+type executeLoadQueryPostHeader struct {
+	FileId uint32
+	StartPos uint32
+	EndPos uint32
+	DupHandling LoadDupHandling
+}
 
-		event.rows = append(event.rows, &row)
-	}
+// A LOAD DATA statement replayed from a file sent with BEGIN_LOAD_QUERY_EVENT.
+// The file name in the query lies between StartPos and EndPos.
+type ExecuteLoadQueryEvent struct {
+	QueryEvent
+	load executeLoadQueryPostHeader
+}
 
+func parseExecuteLoadQueryEvent(buf *bytes.Buffer) (event *ExecuteLoadQueryEvent, err error) {
+	event = new(ExecuteLoadQueryEvent)
+	err = event.QueryEvent.read(buf, &event.load)
 	return
 }
 
-func (event *RowsEvent) Header() (*EventHeader) {
-	return &event.header
+// DupHandling tells whether the LOAD DATA statement was run with IGNORE,
+// REPLACE or neither (LOAD_DUP_ERROR).
+func (event *ExecuteLoadQueryEvent) DupHandling() (LoadDupHandling) {
+	return event.load.DupHandling
 }
 
-func (event *RowsEvent) Print() {
-	event.header.Print()
-	fmt.Printf("tableId: %v, flags: %v, columnsPresentBitmap1: %x, columnsPresentBitmap2: %x\n",
-	           event.tableId, event.flags, event.columnsPresentBitmap1, event.columnsPresentBitmap2)
+func (event *ExecuteLoadQueryEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
 
-	tableMap := event.tableMap
-	for i, row := range event.rows {
-		fmt.Printf("row[%d]:\n", i)
-		for j, col := range *row {
-			colType := tableMap.columnTypes[j]
-			typeName := fieldTypeName(colType)
-			switch colType {
-			case FIELD_TYPE_VARCHAR, FIELD_TYPE_BLOB:
-				fmt.Printf("  %s: %#v\n", typeName, col)
-			default:
-				fmt.Printf("  %s: %v\n", typeName, col)
-			}
-		}
-	}
+func (event *ExecuteLoadQueryEvent) PrintTo(w io.Writer) {
+	event.QueryEvent.PrintTo(w)
+	fmt.Fprintf(w, "fileId: %v, startPos: %v, endPos: %v, dupHandling: %v\n",
+	            event.load.FileId, event.load.StartPos, event.load.EndPos, event.load.DupHandling)
 }
 
+func (event *ExecuteLoadQueryEvent) MarshalJSON() ([]byte, error) {
+	fields := event.QueryEvent.jsonFields()
+	fields["fileId"] = event.load.FileId
+	fields["startPos"] = event.load.StartPos
+	fields["endPos"] = event.load.EndPos
+	fields["dupHandling"] = event.load.DupHandling
+	return marshalEvent(&event.header, fields)
+}
 
-type TableMapEvent struct {
+
+type RowsQueryEvent struct {
 	header EventHeader
-	tableId uint64
-	flags uint16
-	schemaName string
-	tableName string
-	columnTypes []FieldType
-	columnMeta []uint16
-	nullBitmap Bitfield
+	query string
 }
 
-func (event *TableMapEvent) parseColumnMetadata(data []byte) (error) {
-	pos := 0
-	event.columnMeta = make([]uint16, len(event.columnTypes))
-	for i, t := range event.columnTypes {
-		switch t {
-		case FIELD_TYPE_STRING,
-		     FIELD_TYPE_VAR_STRING,
-		     FIELD_TYPE_VARCHAR,
-		     FIELD_TYPE_DECIMAL,
-		     FIELD_TYPE_NEWDECIMAL,
-		     FIELD_TYPE_ENUM,
-		     FIELD_TYPE_SET:
-			event.columnMeta[i] = bytesToUint16(data[pos:pos+2])
-			pos += 2
-
+/* Rows Query Event
+Bytes                        Name
+-----                        ----
+1                            length (truncated to 255, unused)
+n                            query text, up to the end of the event
+*/
+func parseRowsQueryEvent(buf *bytes.Buffer) (event *RowsQueryEvent, err error) {
+	event = new(RowsQueryEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	if _, err = buf.ReadByte(); err != nil {
+		return
+	}
+	event.query = buf.String()
+	return
+}
+
+func (event *RowsQueryEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+// Query returns the statement that produced the following rows events
+func (event *RowsQueryEvent) Query() (string) {
+	return event.query
+}
+
+func (event *RowsQueryEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *RowsQueryEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "query: %#v\n", event.query)
+}
+
+func (event *RowsQueryEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"query": event.query,
+	})
+}
+
+
+// Logged when the server shuts down cleanly
+type StopEvent struct {
+	header EventHeader
+}
+
+func parseStopEvent(buf *bytes.Buffer) (event *StopEvent, err error) {
+	event = new(StopEvent)
+	err = binary.Read(buf, binary.LittleEndian, &event.header)
+	return
+}
+
+func (event *StopEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+func (event *StopEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *StopEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+}
+
+func (event *StopEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, nil)
+}
+
+
+type IncidentEvent struct {
+	header EventHeader
+	incident uint16
+	message string
+}
+
+/* Incident Event
+Bytes                        Name
+-----                        ----
+2                            incident number
+1                            message length
+n                            message
+*/
+func parseIncidentEvent(buf *bytes.Buffer) (event *IncidentEvent, err error) {
+	event = new(IncidentEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &event.incident); err != nil {
+		return
+	}
+	var messageLength byte
+	if messageLength, err = buf.ReadByte(); err != nil {
+		return
+	}
+	var message []byte
+	if message, err = readBytes(buf, int(messageLength)); err != nil {
+		return
+	}
+	event.message = string(message)
+	return
+}
+
+func (event *IncidentEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+// Incident returns the incident number, e.g. INCIDENT_LOST_EVENTS
+func (event *IncidentEvent) Incident() (uint16) {
+	return event.incident
+}
+
+func (event *IncidentEvent) Message() (string) {
+	return event.message
+}
+
+func (event *IncidentEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *IncidentEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "incident: %v, message: %#v\n", event.incident, event.message)
+}
+
+func (event *IncidentEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"incident": event.incident,
+		"message": event.message,
+	})
+}
+
+
+type FormatDescriptionEvent struct {
+	header EventHeader
+	binlogVersion uint16
+	mysqlServerVersion string
+	createTimestamp uint32
+	eventHeaderLength uint8
+	eventTypeHeaderLengths []uint8
+	checksumAlgorithm uint8
+}
+
+const (
+	BINLOG_CHECKSUM_ALG_OFF uint8 = 0
+	BINLOG_CHECKSUM_ALG_CRC32 uint8 = 1
+	BINLOG_CHECKSUM_ALG_UNDEF uint8 = 255
+)
+
+const BINLOG_CHECKSUM_LEN = 4
+
+// Servers from 5.6.1 on end the format description event with the checksum
+// algorithm byte followed by the event's own checksum, whether or not
+// checksums are enabled.
+func versionHasChecksum(version string) (bool) {
+	var major, minor, patch int
+	fmt.Sscanf(version, "%d.%d.%d", &major, &minor, &patch)
+	switch {
+	case major != 5:
+		return major > 5
+	case minor != 6:
+		return minor > 6
+	}
+	return patch >= 1
+}
+
+func parseFormatDescriptionEvent(buf *bytes.Buffer) (event *FormatDescriptionEvent, err error) {
+	event = new(FormatDescriptionEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &event.binlogVersion); err != nil {
+		return
+	}
+	var version []byte
+	if version, err = readBytes(buf, 50); err != nil {
+		return
+	}
+	event.mysqlServerVersion = string(version)
+	if err = binary.Read(buf, binary.LittleEndian, &event.createTimestamp); err != nil {
+		return
+	}
+	if event.eventHeaderLength, err = buf.ReadByte(); err != nil {
+		return
+	}
+	event.eventTypeHeaderLengths = buf.Bytes()
+	event.checksumAlgorithm = BINLOG_CHECKSUM_ALG_UNDEF
+	if n := len(event.eventTypeHeaderLengths) - 1 - BINLOG_CHECKSUM_LEN; n >= 0 && versionHasChecksum(event.mysqlServerVersion) {
+		event.checksumAlgorithm = event.eventTypeHeaderLengths[n]
+		event.eventTypeHeaderLengths = event.eventTypeHeaderLengths[:n]
+	}
+	return
+}
+
+func (event *FormatDescriptionEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+// HeaderLength returns the post-header length the server uses for events of
+// type t, or 0 if the format description doesn't cover that type or no
+// format description was received yet.
+func (event *FormatDescriptionEvent) HeaderLength(t EventType) (uint8) {
+	if event == nil || t == UNKNOWN_EVENT || int(t) > len(event.eventTypeHeaderLengths) {
+		return 0
+	}
+	return event.eventTypeHeaderLengths[t - 1]
+}
+
+// ChecksumAlgorithm returns the checksum algorithm of the events following
+// this one: BINLOG_CHECKSUM_ALG_OFF, BINLOG_CHECKSUM_ALG_CRC32, or
+// BINLOG_CHECKSUM_ALG_UNDEF for servers older than 5.6.1.
+func (event *FormatDescriptionEvent) ChecksumAlgorithm() (uint8) {
+	return event.checksumAlgorithm
+}
+
+// Flavor returns the flavor of the server that logged the binlog file
+func (event *FormatDescriptionEvent) Flavor() (Flavor) {
+	return flavorOfVersion(event.mysqlServerVersion)
+}
+
+func (event *FormatDescriptionEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *FormatDescriptionEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "binlogVersion: %v, mysqlServerVersion: %v, createTimestamp: %v, eventHeaderLength: %v, eventTypeHeaderLengths: %#v, checksumAlgorithm: %v\n",
+	            event.binlogVersion, event.mysqlServerVersion, event.createTimestamp, event.eventHeaderLength, event.eventTypeHeaderLengths, event.checksumAlgorithm)
+}
+
+func (event *FormatDescriptionEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"binlogVersion": event.binlogVersion,
+		"mysqlServerVersion": event.mysqlServerVersion,
+		"createTimestamp": formatJSONTimestamp(event.createTimestamp),
+		"eventHeaderLength": event.eventHeaderLength,
+		"checksumAlgorithm": event.checksumAlgorithm,
+	})
+}
+
+
+type RowsEvent struct {
+	header EventHeader
+	tableId uint64
+	tableMap *TableMapEvent
+	flags uint16
+	columnsPresentBitmap1 Bitfield
+	columnsPresentBitmap2 Bitfield
+	extraData []byte
+	rows [][]driver.Value
+	rawRows [][][]byte
+}
+
+// Reads the null bitmap of a row image, which only has bits for the columns
+// flagged in columnsPresent
+func readNullBitmap(buf *bytes.Buffer, columnsCount int, columnsPresent Bitfield) (Bitfield, error) {
+	bitmap, e := readBytes(buf, (columnsPresent.count(columnsCount) + 7) / 8)
+	return Bitfield(bitmap), e
+}
+
+// Reads exactly n bytes of a value. Empty values come back as an empty slice,
+// never nil, since nil row values mean NULL.
+func readBytes(buf *bytes.Buffer, n int) ([]byte, error) {
+	if n < 0 || buf.Len() < n {
+		return nil, &ErrTruncatedEvent{ReadSize: n, Remaining: buf.Len()}
+	}
+	return buf.Next(n), nil
+}
+
+// ErrTruncatedEvent is returned for events that end before the data their
+// fields announce, e.g. corrupted ones
+type ErrTruncatedEvent struct {
+	EventType EventType
+	// Size of the read that failed and the bytes that were left for it, both
+	// 0 if not known
+	ReadSize int
+	Remaining int
+}
+
+func (e *ErrTruncatedEvent) Error() string {
+	if e.ReadSize == 0 {
+		return fmt.Sprintf("Truncated %s", e.EventType)
+	}
+	return fmt.Sprintf("Truncated %s: read of %d bytes with %d left", e.EventType, e.ReadSize, e.Remaining)
+}
+
+// Turns the EOF errors of a short read while parsing an event of type t into
+// an ErrTruncatedEvent
+func truncatedEventError(t EventType, e error) error {
+	switch err := e.(type) {
+	case *ErrTruncatedEvent:
+		// Set by the innermost event, e.g. in a transaction payload
+		if err.EventType == UNKNOWN_EVENT {
+			err.EventType = t
+		}
+	default:
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			return &ErrTruncatedEvent{EventType: t}
+		}
+	}
+	return e
+}
+
+// Reads the fractional seconds part of TIMESTAMP2, DATETIME2 and TIME2 values
+// and returns it in microseconds. fsp is the column's fractional seconds
+// precision; (fsp + 1) / 2 big-endian bytes hold hundredths, ten-thousandths
+// or microseconds.
+func readFractionalSeconds(buf *bytes.Buffer, fsp uint16) (usec int, e error) {
+	if fsp > 6 {
+		return 0, fmt.Errorf("Invalid fractional seconds precision %d", fsp)
+	}
+	size := int(fsp + 1) / 2
+	data, e := readBytes(buf, size)
+	if e != nil {
+		return 0, e
+	}
+	usec = int(bytesToUintBE(data))
+	for i := size; i < 3; i++ {
+		usec *= 100
+	}
+	return
+}
+
+/* TIME2 value as "[-]HH:MM:SS[.ffffff]" with fsp fractional digits
+3 bytes big-endian, offset by 1 << 23 (the sign bit), then (fsp + 1) / 2
+bytes of fractional seconds like readFractionalSeconds.
+Bits                         Name
+----                         ----
+1                            sign
+1                            unused
+10                           hour
+6                            minute
+6                            second
+Negative values are stored as the offset integral part minus one plus the
+complement of the fraction, so both parts have to be decoded together.
+*/
+func readTime2(buf *bytes.Buffer, fsp uint16) (value string, e error) {
+	if fsp > 6 {
+		return "", fmt.Errorf("Invalid fractional seconds precision %d", fsp)
+	}
+	fracSize := int(fsp + 1) / 2
+	data, e := readBytes(buf, 3 + fracSize)
+	if e != nil {
+		return "", e
+	}
+
+	// Packed as the integral part << 24 plus microseconds
+	var packed int64
+	switch fracSize {
+	case 0:
+		packed = (int64(bytesToUintBE(data)) - 0x800000) << 24
+	case 1, 2:
+		integral := int64(bytesToUintBE(data[:3])) - 0x800000
+		frac := int64(bytesToUintBE(data[3:]))
+		if integral < 0 && frac != 0 {
+			integral++
+			frac -= 1 << (8 * uint(fracSize))
+		}
+		if fracSize == 1 {
+			frac *= 10000
+		} else {
+			frac *= 100
+		}
+		packed = integral << 24 + frac
+	case 3:
+		packed = int64(bytesToUintBE(data)) - 0x800000000000
+	}
+
+	sign := ""
+	if packed < 0 {
+		sign = "-"
+		packed = -packed
+	}
+	clock := packed >> 24
+	value = fmt.Sprintf("%s%02d:%02d:%02d", sign, (clock >> 12) & 1023, (clock >> 6) & 63, clock & 63)
+	if fsp > 0 {
+		usec := packed & (1 << 24 - 1)
+		for i := fsp; i < 6; i++ {
+			usec /= 10
+		}
+		value += fmt.Sprintf(".%0*d", int(fsp), usec)
+	}
+	return
+}
+
+// Returns the length prefix size of a BLOB-family column. Servers log all of
+// them as BLOB with the size in the meta, the sized types are fixed.
+func blobLengthSize(t FieldType, meta uint16) (uint16) {
+	switch t {
+	case FIELD_TYPE_TINY_BLOB:
+		return 1
+	case FIELD_TYPE_MEDIUM_BLOB:
+		return 3
+	case FIELD_TYPE_LONG_BLOB:
+		return 4
+	}
+	return meta
+}
+
+// Returns the size in bytes of an integer column type
+func integerSize(t FieldType) (int) {
+	switch t {
+	case FIELD_TYPE_TINY:
+		return 1
+	case FIELD_TYPE_SHORT:
+		return 2
+	case FIELD_TYPE_INT24:
+		return 3
+	case FIELD_TYPE_LONG:
+		return 4
+	}
+	return 8
+}
+
+// Reads a little-endian integer column value: uint64 if the column is
+// UNSIGNED, int64 otherwise.
+func readInteger(buf *bytes.Buffer, size int, unsigned bool) (driver.Value, error) {
+	n, e := readFixedLengthInteger(buf, size)
+	if e != nil {
+		return nil, e
+	}
+	if unsigned {
+		return n, nil
+	}
+	shift := uint(64 - size * 8)
+	return int64(n << shift) >> shift, nil
+}
+
+// Reads a BLOB-style value. The column meta is the size of its little-endian
+// length prefix: 1 for TINYBLOB up to 4 for LONGBLOB.
+func readBlob(buf *bytes.Buffer, prefixSize uint16) ([]byte, error) {
+	if prefixSize < 1 || prefixSize > 4 {
+		return nil, fmt.Errorf("Invalid length prefix size %d", prefixSize)
+	}
+	length, e := readFixedLengthInteger(buf, int(prefixSize))
+	if e != nil {
+		return nil, e
+	}
+	return readBytes(buf, int(length))
+}
+
+// Reads a little-endian length prefix of prefixSize bytes and returns the total
+// size of the prefixed value, prefix included
+func prefixedFieldLength(data []byte, prefixSize int) (n int, e error) {
+	if len(data) < prefixSize {
+		return 0, io.EOF
+	}
+	for i := 0; i < prefixSize; i++ {
+		n |= int(data[i]) << (uint(i) * 8)
+	}
+	return prefixSize + n, nil
+}
+
+// Returns the number of bytes the column value at the start of data takes up,
+// without decoding it
+func fieldLength(data []byte, t FieldType, meta uint16) (n int, e error) {
+	switch t {
+	case FIELD_TYPE_NULL:
+		n = 0
+	case FIELD_TYPE_TINY, FIELD_TYPE_YEAR:
+		n = 1
+	case FIELD_TYPE_SHORT:
+		n = 2
+	case FIELD_TYPE_INT24, FIELD_TYPE_DATE, FIELD_TYPE_NEWDATE, FIELD_TYPE_TIME:
+		n = 3
+	case FIELD_TYPE_LONG, FIELD_TYPE_FLOAT, FIELD_TYPE_TIMESTAMP:
+		n = 4
+	case FIELD_TYPE_LONGLONG, FIELD_TYPE_DOUBLE, FIELD_TYPE_DATETIME:
+		n = 8
+	case FIELD_TYPE_DECIMAL:
+		if n = int(meta); n == 0 {
+			e = errors.New("Unknown length of pre-5.0 DECIMAL, see Column.DecimalLength")
+		}
+	case FIELD_TYPE_NEWDECIMAL:
+		n = decimalBinarySize(int(meta & 0xff), int(meta >> 8))
+	case FIELD_TYPE_TIMESTAMP2:
+		n = 4 + int(meta + 1) / 2
+	case FIELD_TYPE_DATETIME2:
+		n = 5 + int(meta + 1) / 2
+	case FIELD_TYPE_TIME2:
+		n = 3 + int(meta + 1) / 2
+	case FIELD_TYPE_BIT:
+		n = int(meta >> 8) + int(meta & 0xff + 7) / 8
+	case FIELD_TYPE_ENUM, FIELD_TYPE_SET:
+		n = int(meta >> 8)
+
+	case FIELD_TYPE_VARCHAR, FIELD_TYPE_VAR_STRING:
+		if meta > 255 {
+			n, e = prefixedFieldLength(data, 2)
+		} else {
+			n, e = prefixedFieldLength(data, 1)
+		}
+
+	case FIELD_TYPE_STRING:
+		realType, maxLength := stringFieldInfo(meta)
+		switch {
+		case realType == FIELD_TYPE_ENUM, realType == FIELD_TYPE_SET:
+			n = maxLength
+		case maxLength > 255:
+			n, e = prefixedFieldLength(data, 2)
+		default:
+			n, e = prefixedFieldLength(data, 1)
+		}
+
+	case FIELD_TYPE_BLOB, FIELD_TYPE_TINY_BLOB, FIELD_TYPE_MEDIUM_BLOB, FIELD_TYPE_LONG_BLOB, FIELD_TYPE_GEOMETRY, FIELD_TYPE_JSON:
+		prefixSize := blobLengthSize(t, meta)
+		if prefixSize < 1 || prefixSize > 4 {
+			return 0, fmt.Errorf("Invalid length prefix size %d for %s", prefixSize, fieldTypeName(t))
+		}
+		n, e = prefixedFieldLength(data, int(prefixSize))
+
+	default:
+		return 0, &ErrUnsupportedFieldType{Type: t}
+	}
+	if e == nil && len(data) < n {
+		e = io.EOF
+	}
+	return
+}
+
+// Like parseEventRow, but only splits the row into the raw bytes of each
+// column. NULL and absent columns are left nil.
+func parseRawEventRow(buf *bytes.Buffer, tableMap *TableMapEvent, columnsPresent Bitfield) (row [][]byte, e error) {
+	columnsCount := len(tableMap.columnTypes)
+
+	row = make([][]byte, columnsCount)
+
+	nullBitMap, e := readNullBitmap(buf, columnsCount, columnsPresent)
+	if e != nil {
+		return nil, e
+	}
+
+	nullIndex := uint(0)
+	for i := 0; i < columnsCount; i++ {
+		if !columnsPresent.isSet(uint(i)) {
+			continue
+		}
+		isNull := nullBitMap.isSet(nullIndex)
+		nullIndex++
+		if isNull {
+			continue
+		}
+
+		var n int
+		n, e = fieldLength(buf.Bytes(), tableMap.columnTypes[i], tableMap.columnMeta[i])
+		if unsupported, ok := e.(*ErrUnsupportedFieldType); ok {
+			unsupported.Column = i
+		}
+		if e != nil {
+			return nil, e
+		}
+		row[i] = buf.Next(n)
+	}
+	return
+}
+
+// Number of rows allocated at once by NewRowSlabAllocator
+const rowSlabSize = 32
+
+// NewRowSlabAllocator returns a row allocator for SetRowAllocator that cuts
+// rows from slabs of memory shared by consecutive rows, across events, so that
+// decoding allocates once per 32 rows instead of once per row. Keeping one row
+// keeps its whole slab alive: copy the rows kept for long.
+func NewRowSlabAllocator() (func(columnsCount int) []driver.Value) {
+	var slab []driver.Value
+	return func(columnsCount int) []driver.Value {
+		if len(slab) < columnsCount {
+			slab = make([]driver.Value, columnsCount * rowSlabSize)
+		}
+		row := slab[:columnsCount:columnsCount]
+		slab = slab[columnsCount:]
+		return row
+	}
+}
+
+// Returns a zeroed row of columnsCount values, from the allocator set with
+// SetRowAllocator if any
+func (parser *eventParser) newRow(columnsCount int) ([]driver.Value) {
+	if parser.rowAllocator != nil {
+		if row := parser.rowAllocator(columnsCount); cap(row) >= columnsCount {
+			row = row[:columnsCount]
+			for i := range row {
+				row[i] = nil
+			}
+			return row
+		}
+	}
+	return make([]driver.Value, columnsCount)
+}
+
+// A nil value in the returned row strictly means SQL NULL (or, see below, a
+// column missing from the image). Empty strings and blobs decode to empty
+// non-nil values, and a value that can't be decoded fails the whole row with
+// an error instead of being left nil, an ErrUnsupportedFieldType if the parser
+// doesn't support the column's type.
+//
+// Columns not flagged in columnsPresent aren't part of the row image (see
+// binlog_row_image=MINIMAL/NOBLOB): nothing is read for them, not even a null
+// bit, and they are left nil in the returned row.
+//
+// The JSON columns flagged in partialJSON hold diffs, applied to their raw
+// value in the before image if it has one, see partialJSONValue.
+func (parser *eventParser) parseEventRow(buf *bytes.Buffer, tableMap *TableMapEvent, columnsPresent Bitfield, partialJSON Bitfield, before [][]byte) (row []driver.Value, e error) {
+	columnsCount := len(tableMap.columnTypes)
+
+	row = parser.newRow(columnsCount)
+
+	nullBitMap, e := readNullBitmap(buf, columnsCount, columnsPresent)
+	if e != nil {
+		return nil, e
+	}
+
+	nullIndex := uint(0)
+	for i := 0; i < columnsCount; i++ {
+		if !columnsPresent.isSet(uint(i)) {
+			continue
+		}
+		isNull := nullBitMap.isSet(nullIndex)
+		nullIndex++
+		if isNull {
+			row[i] = nil
+			continue
+		}
+
+		switch tableMap.columnTypes[i] {
+		case FIELD_TYPE_NULL:
+			row[i] = nil
+
+		case FIELD_TYPE_TINY, FIELD_TYPE_SHORT, FIELD_TYPE_INT24, FIELD_TYPE_LONG, FIELD_TYPE_LONGLONG:
+			row[i], e = readInteger(buf, integerSize(tableMap.columnTypes[i]), tableMap.column(i).Unsigned)
+
+		case FIELD_TYPE_YEAR:
+			// Stored as years since 1900, or 0 for the zero year 0000. Either
+			// way it decodes to a time.Time on January 1st of the year.
+			var b byte
+			b, e = buf.ReadByte()
+			year := 0
+			if b != 0 {
+				year = int(b) + 1900
+			}
+			row[i] = time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+		// binary.Read allocates, which adds up over many rows
+		case FIELD_TYPE_FLOAT:
+			var bits uint64
+			bits, e = readFixedLengthInteger(buf, 4)
+			row[i] = float64(math.Float32frombits(uint32(bits)))
+
+		case FIELD_TYPE_DOUBLE:
+			var bits uint64
+			bits, e = readFixedLengthInteger(buf, 8)
+			row[i] = math.Float64frombits(bits)
+
+		case FIELD_TYPE_DECIMAL:
+			row[i], e = readOldDecimal(buf, i, tableMap.columnMeta[i])
+
+		case FIELD_TYPE_NEWDECIMAL:
+			precision := int(tableMap.columnMeta[i] & 0xff)
+			scale := int(tableMap.columnMeta[i] >> 8)
+			var data []byte
+			if data, e = readBytes(buf, decimalBinarySize(precision, scale)); e == nil {
+				row[i], e = DecodeDecimal(data, precision, scale)
+			}
+
+		case FIELD_TYPE_VARCHAR, FIELD_TYPE_VAR_STRING:
+			max_length := tableMap.columnMeta[i]
+			var length int
+			if max_length > 255 {
+				var short uint64
+				short, e = readFixedLengthInteger(buf, 2)
+				length = int(short)
+			} else {
+				var b byte
+				b, e = buf.ReadByte()
+				length = int(b)
+			}
+			if e != nil {
+				return nil, e
+			}
+			var value []byte
+			if value, e = readBytes(buf, length); e != nil {
+				return nil, e
+			}
+			row[i], e = parser.stringValue(tableMap, i, value)
+
+		case FIELD_TYPE_BLOB, FIELD_TYPE_TINY_BLOB, FIELD_TYPE_MEDIUM_BLOB, FIELD_TYPE_LONG_BLOB:
+			var value []byte
+			if value, e = readBlob(buf, blobLengthSize(tableMap.columnTypes[i], tableMap.columnMeta[i])); e != nil {
+				return nil, e
+			}
+			row[i], e = parser.stringValue(tableMap, i, value)
+
+		case FIELD_TYPE_GEOMETRY:
+			// A 4 byte SRID followed by the WKB geometry, stored like a BLOB
+			row[i], e = readBlob(buf, tableMap.columnMeta[i])
+
+		case FIELD_TYPE_JSON:
+			var value []byte
+			if value, e = readBlob(buf, tableMap.columnMeta[i]); e != nil {
+				return nil, e
+			}
+			if partialJSON != nil && partialJSON.isSet(uint(i)) {
+				row[i], e = parser.partialJSONValue(tableMap, i, value, before)
+				break
+			}
+			if value, e = decodeJSON(value); e != nil {
+				return nil, e
+			}
+			row[i], e = parser.stringValue(tableMap, i, value)
+
+		case FIELD_TYPE_STRING:
+			realType, maxLength := stringFieldInfo(tableMap.columnMeta[i])
+			if realType == FIELD_TYPE_ENUM {
+				row[i], e = readEnum(buf, maxLength, tableMap.column(i).EnumValues)
+				break
+			}
+			if realType == FIELD_TYPE_SET {
+				row[i], e = readSet(buf, maxLength, tableMap.column(i).SetValues)
+				break
+			}
+			if realType != FIELD_TYPE_STRING {
+				e = &ErrUnsupportedFieldType{Type: realType, Column: i}
+				break
+			}
+			var length int
+			if maxLength > 255 {
+				var short uint64
+				short, e = readFixedLengthInteger(buf, 2)
+				length = int(short)
+			} else {
+				var b byte
+				b, e = buf.ReadByte()
+				length = int(b)
+			}
+			if e != nil {
+				return nil, e
+			}
+			var value []byte
+			if value, e = readBytes(buf, length); e != nil {
+				return nil, e
+			}
+			if tableMap.isBinary(i) {
+				// BINARY(n) is padded with zero bytes, which the binlog strips
+				if length > maxLength {
+					return nil, fmt.Errorf("Value of %d bytes in BINARY(%d) column %d", length, maxLength, i)
+				}
+				padded := make([]byte, maxLength)
+				copy(padded, value)
+				row[i] = padded
+			} else {
+				row[i], e = parser.stringValue(tableMap, i, value)
+			}
+
+		case FIELD_TYPE_ENUM:
+			row[i], e = readEnum(buf, int(tableMap.columnMeta[i] >> 8), tableMap.column(i).EnumValues)
+
+		case FIELD_TYPE_SET:
+			row[i], e = readSet(buf, int(tableMap.columnMeta[i] >> 8), tableMap.column(i).SetValues)
+
+		case FIELD_TYPE_DATE, FIELD_TYPE_NEWDATE:
+			// 15 bits year, 4 bits month, 5 bits day
+			var date uint64
+			date, e = readFixedLengthInteger(buf, 3)
+			if e == nil {
+				if date == 0 {
+					// 0000-00-00
+					row[i] = time.Time{}
+				} else {
+					row[i] = time.Date(int(date >> 9), time.Month((date >> 5) & 15), int(date & 31), 0, 0, 0, 0, time.UTC)
+				}
+			}
+
+		case FIELD_TYPE_TIME:
+			e = &ErrUnsupportedFieldType{Type: tableMap.columnTypes[i], Column: i}
+
+		case FIELD_TYPE_TIMESTAMP:
+			// Seconds since the epoch, 0 is 0000-00-00 00:00:00
+			var seconds uint32
+			e = binary.Read(buf, binary.LittleEndian, &seconds)
+			if seconds == 0 {
+				row[i] = time.Time{}
+			} else {
+				row[i] = time.Unix(int64(seconds), 0).In(parser.location)
+			}
+
+		case FIELD_TYPE_TIMESTAMP2:
+			// Big-endian seconds since the epoch, then the fractional part
+			var seconds uint32
+			var usec int
+			if e = binary.Read(buf, binary.BigEndian, &seconds); e != nil {
+				return nil, e
+			}
+			if usec, e = readFractionalSeconds(buf, tableMap.columnMeta[i]); e != nil {
+				return nil, e
+			}
+			if seconds == 0 && usec == 0 {
+				row[i] = time.Time{}
+			} else {
+				row[i] = time.Unix(int64(seconds), int64(usec) * 1000).In(parser.location)
+			}
+
+		case FIELD_TYPE_DATETIME2:
+			/* 5 bytes big-endian, offset by 1 << 39 (the sign bit)
+			Bits                         Name
+			----                         ----
+			1                            sign
+			17                           year * 13 + month
+			5                            day
+			5                            hour
+			6                            minute
+			6                            second
+			*/
+			var data []byte
+			var usec int
+			if data, e = readBytes(buf, 5); e != nil {
+				return nil, e
+			}
+			if usec, e = readFractionalSeconds(buf, tableMap.columnMeta[i]); e != nil {
+				return nil, e
+			}
+			packed := int64(bytesToUintBE(data)) - 0x8000000000
+			if packed == 0 && usec == 0 {
+				// 0000-00-00 00:00:00
+				row[i] = time.Time{}
+				break
+			}
+			date := packed >> 17
+			yearMonth := date >> 5
+			clock := packed & (1 << 17 - 1)
+			row[i] = time.Date(int(yearMonth / 13), time.Month(yearMonth % 13), int(date & 31),
+			                   int(clock >> 12), int((clock >> 6) & 63), int(clock & 63), usec * 1000, time.UTC)
+
+		case FIELD_TYPE_TIME2:
+			row[i], e = readTime2(buf, tableMap.columnMeta[i])
+
+		case FIELD_TYPE_BIT:
+			// The meta holds the number of full bytes in the high byte and
+			// the remaining bits in the low byte
+			bits := uint(tableMap.columnMeta[i] >> 8) * 8 + uint(tableMap.columnMeta[i] & 0xff)
+			var data []byte
+			if data, e = readBytes(buf, int(bits + 7) / 8); e != nil {
+				return nil, e
+			}
+			value := bytesToUintBE(data)
+			if bits < 64 {
+				value &= 1 << bits - 1
+			}
+			row[i] = value
+
+		case FIELD_TYPE_DATETIME:
+			// The decimal digits YYYYMMDDhhmmss as an integer
+			var t int64
+			if e = binary.Read(buf, binary.LittleEndian, &t); e != nil {
+				return nil, e
+			}
+			if t == 0 {
+				// 0000-00-00 00:00:00
+				row[i] = time.Time{}
+				break
+			}
+
+			second := int(t % 100)
+			minute := int((t % 10000) / 100)
+			hour := int((t % 1000000) / 10000)
+
+			d := int(t / 1000000)
+			day := d % 100
+			month := time.Month((d % 10000) / 100)
+			year := d / 10000
+
+			row[i] = time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+
+		default:
+			e = &ErrUnsupportedFieldType{Type: tableMap.columnTypes[i], Column: i}
+		}
+		if unsupported, ok := e.(*ErrUnsupportedFieldType); ok && parser.skipUnsupported {
+			// Nothing was read for the column yet
+			if n, lengthErr := fieldLength(buf.Bytes(), tableMap.columnTypes[i], tableMap.columnMeta[i]); lengthErr == nil {
+				row[i] = UnsupportedValue{Type: unsupported.Type, Data: buf.Next(n)}
+				e = nil
+			}
+		}
+		if e != nil {
+			return nil, e
+		}
+	}
+	return
+}
+
+func (parser *eventParser) parseRowsEvent(buf *bytes.Buffer) (event *RowsEvent, err error) {
+	var columnCount uint64
+
+	event = new(RowsEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+
+	headerSize := parser.format.HeaderLength(event.header.EventType)
+	var tableIdSize int
+	if headerSize == 6 {
+		tableIdSize = 4
+	} else {
+		tableIdSize = 6
+	}
+	if event.tableId, err = readFixedLengthInteger(buf, tableIdSize); err != nil {
+		return
+	}
+
+	if err = binary.Read(buf, binary.LittleEndian, &event.flags); err != nil {
+		return
+	}
+	switch event.header.EventType {
+	case WRITE_ROWS_EVENTv2, UPDATE_ROWS_EVENTv2, DELETE_ROWS_EVENTv2, PARTIAL_UPDATE_ROWS_EVENT:
+		// The extra data length includes its own 2 bytes
+		var extraDataLength uint16
+		if err = binary.Read(buf, binary.LittleEndian, &extraDataLength); err != nil {
+			return
+		}
+		if extraDataLength < 2 {
+			err = fmt.Errorf("Invalid rows event extra data length %d", extraDataLength)
+			return
+		}
+		if event.extraData, err = readBytes(buf, int(extraDataLength) - 2); err != nil {
+			return
+		}
+	}
+	if columnCount, _, err = readLengthEncodedInt(buf); err != nil {
+		return
+	}
+
+	var bitmap []byte
+	if bitmap, err = readBytes(buf, int((columnCount + 7) / 8)); err != nil {
+		return
+	}
+	event.columnsPresentBitmap1 = Bitfield(bitmap)
+	// The v0 events of MySQL 5.1.0-5.1.17 are laid out like v1, except that
+	// updates have a single bitmap for both images
+	switch event.header.EventType {
+	case UPDATE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv2, PARTIAL_UPDATE_ROWS_EVENT:
+		if bitmap, err = readBytes(buf, int((columnCount + 7) / 8)); err != nil {
+			return
+		}
+		event.columnsPresentBitmap2 = Bitfield(bitmap)
+	}
+
+	event.tableMap = parser.lookupTableMap(event.tableId)
+	if event.tableMap == nil {
+		err = fmt.Errorf("Rows event for unknown table id %d", event.tableId)
+		return
+	}
+	if columnCount != uint64(event.tableMap.ColumnCount()) {
+		err = fmt.Errorf("Rows event has %d columns, its table map %d", columnCount, event.tableMap.ColumnCount())
+		return
+	}
+	if event.tableMap.filtered {
+		return
+	}
+	// An image without columns takes no bytes, so there would be no end to
+	// the rows
+	if buf.Len() > 0 && (event.columnsPresentBitmap1.count(int(columnCount)) == 0 ||
+		event.columnsPresentBitmap2 != nil && event.columnsPresentBitmap2.count(int(columnCount)) == 0) {
+		err = fmt.Errorf("Rows event has images without columns")
+		return
+	}
+	var before [][]byte
+	for buf.Len() > 0 {
+		// Update events alternate before and after images, which each have
+		// their own present bitmap
+		columnsPresent := event.columnsPresentBitmap1
+		isAfter := event.columnsPresentBitmap2 != nil && (len(event.rows) + len(event.rawRows)) % 2 == 1
+		if isAfter {
+			columnsPresent = event.columnsPresentBitmap2
+		}
+
+		var partialJSON Bitfield
+		if event.header.EventType == PARTIAL_UPDATE_ROWS_EVENT {
+			if isAfter {
+				if partialJSON, err = readPartialJSONBitmap(buf, event.tableMap); err != nil {
+					return
+				}
+			} else if !parser.rawMode {
+				// Diffs in the after image apply to the raw JSON
+				if before, err = parseRawEventRow(bytes.NewBuffer(buf.Bytes()), event.tableMap, columnsPresent); err != nil {
+					return
+				}
+			}
+		}
+
+		if parser.rawMode {
+			var rawRow [][]byte
+			rawRow, err = parseRawEventRow(buf, event.tableMap, columnsPresent)
+			if err != nil {
+				return
+			}
+			event.rawRows = append(event.rawRows, rawRow)
+			continue
+		}
+
+		var row []driver.Value
+		row, err = parser.parseEventRow(buf, event.tableMap, columnsPresent, partialJSON, before)
+		if err != nil {
+			return
+		}
+
+		event.rows = append(event.rows, row)
+	}
+
+	return
+}
+
+// Value options of the after images of PARTIAL_UPDATE_ROWS_EVENT
+const PARTIAL_JSON_UPDATES = 1
+
+/* Layout of the start of an after image of PARTIAL_UPDATE_ROWS_EVENT
+ * value options                     length encoded integer
+ * partial bitmap (if PARTIAL_JSON_UPDATES)
+ *                                   a bit per JSON column of the table
+ *
+ * Returns the partial bits indexed by column instead, nil if there are none.
+ */
+func readPartialJSONBitmap(buf *bytes.Buffer, tableMap *TableMapEvent) (Bitfield, error) {
+	options, _, e := readLengthEncodedInt(buf)
+	if e != nil || options & PARTIAL_JSON_UPDATES == 0 {
+		return nil, e
+	}
+	var jsonColumns []int
+	for i, t := range tableMap.columnTypes {
+		if t == FIELD_TYPE_JSON {
+			jsonColumns = append(jsonColumns, i)
+		}
+	}
+	bitmap, e := readBytes(buf, (len(jsonColumns) + 7) / 8)
+	if e != nil {
+		return nil, e
+	}
+	partial := NewBitfield(uint(len(tableMap.columnTypes)))
+	for j, i := range jsonColumns {
+		if Bitfield(bitmap).isSet(uint(j)) {
+			partial[i / 8] |= 1 << (uint(i) % 8)
+		}
+	}
+	return partial, nil
+}
+
+// Decodes the diffs logged for JSON column i and applies them to its value in
+// the before image, which is raw (including its length prefix). The diffs
+// are returned as []JSONDiff if the before image doesn't have the column,
+// e.g. with binlog_row_image=MINIMAL.
+func (parser *eventParser) partialJSONValue(tableMap *TableMapEvent, i int, data []byte, before [][]byte) (driver.Value, error) {
+	diffs, e := parseJSONDiffs(data)
+	if e != nil {
+		return nil, e
+	}
+	if i >= len(before) || before[i] == nil {
+		return decodeJSONDiffs(diffs)
+	}
+	value, e := applyJSONDiffs(before[i][tableMap.columnMeta[i]:], diffs)
+	if e != nil {
+		return nil, e
+	}
+	return parser.stringValue(tableMap, i, value)
+}
+
+func (event *RowsEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+// Rows returns the decoded rows. Update events alternate the before and after
+// image of each changed row. Each row is allocated on its own, unless an
+// allocator was set with SetRowAllocator.
+//
+// ENUM values are their label, or the int64 index of the label if the labels
+// aren't known (see TableMapEvent.Columns). SET values are their members
+// joined by commas, or the uint64 bitmask if the member names aren't known.
+//
+// The JSON columns that PARTIAL_UPDATE_ROWS_EVENT logs as diffs come out as
+// the updated document, or as []JSONDiff when the before image lacks the
+// column.
+func (event *RowsEvent) Rows() ([][]driver.Value) {
+	return event.rows
+}
+
+// Filtered tells whether the table filter set with SetTableFilter rejected the
+// event's table, in which case its rows were skipped and Rows returns none.
+func (event *RowsEvent) Filtered() (bool) {
+	return event.tableMap.filtered
+}
+
+// The kind of row change a rows event logs
+type RowsOperation uint8
+
+const (
+	ROWS_UNKNOWN RowsOperation = iota
+	ROWS_INSERT
+	ROWS_UPDATE
+	ROWS_DELETE
+)
+
+func (op RowsOperation) String() string {
+	switch op {
+	case ROWS_INSERT:
+		return "INSERT"
+	case ROWS_UPDATE:
+		return "UPDATE"
+	case ROWS_DELETE:
+		return "DELETE"
+	}
+	return "UNKNOWN"
+}
+
+// Operation tells whether the event inserts, updates or deletes its rows
+func (event *RowsEvent) Operation() (RowsOperation) {
+	switch event.header.EventType {
+	case WRITE_ROWS_EVENTv0, WRITE_ROWS_EVENTv1, WRITE_ROWS_EVENTv2:
+		return ROWS_INSERT
+	case UPDATE_ROWS_EVENTv0, UPDATE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv2, PARTIAL_UPDATE_ROWS_EVENT:
+		return ROWS_UPDATE
+	case DELETE_ROWS_EVENTv0, DELETE_ROWS_EVENTv1, DELETE_ROWS_EVENTv2:
+		return ROWS_DELETE
+	}
+	return ROWS_UNKNOWN
+}
+
+// RowMaps returns the rows keyed by the column names, or nil if they aren't
+// known (see TableMapEvent.ColumnNames). Columns missing from
+// partial row images are left out of the maps, NULL columns map to nil.
+func (event *RowsEvent) RowMaps() ([]map[string]driver.Value) {
+	names := event.ColumnNames()
+	if names == nil {
+		return nil
+	}
+	maps := make([]map[string]driver.Value, len(event.rows))
+	for i, row := range event.rows {
+		columnsPresent := event.columnsPresentBitmap1
+		if event.columnsPresentBitmap2 != nil && i % 2 == 1 {
+			columnsPresent = event.columnsPresentBitmap2
+		}
+		maps[i] = make(map[string]driver.Value, len(names))
+		for j, value := range row {
+			if columnsPresent.isSet(uint(j)) {
+				maps[i][names[j]] = value
+			}
+		}
+	}
+	return maps
+}
+
+// UpdatePair holds the images of a row before and after an update
+type UpdatePair struct {
+	Before []driver.Value
+	After []driver.Value
+}
+
+// UpdatePairs returns the rows of an update event as before/after pairs
+func (event *RowsEvent) UpdatePairs() ([]UpdatePair, error) {
+	if event.Operation() != ROWS_UPDATE {
+		return nil, fmt.Errorf("UpdatePairs called on %s", event.header.EventName())
+	}
+	if len(event.rows) % 2 != 0 {
+		return nil, fmt.Errorf("Update event has an odd number of row images: %d", len(event.rows))
+	}
+	pairs := make([]UpdatePair, len(event.rows) / 2)
+	for i := range pairs {
+		pairs[i] = UpdatePair{event.rows[2 * i], event.rows[2 * i + 1]}
+	}
+	return pairs, nil
+}
+
+func (event *RowsEvent) SchemaName() (string) {
+	return event.tableMap.SchemaName()
+}
+
+func (event *RowsEvent) TableName() (string) {
+	return event.tableMap.TableName()
+}
+
+func (event *RowsEvent) ColumnTypes() ([]FieldType) {
+	return event.tableMap.ColumnTypes()
+}
+
+func (event *RowsEvent) ColumnNames() ([]string) {
+	return event.tableMap.ColumnNames()
+}
+
+// Columns returns the columns of the table, see TableMapEvent.Columns, e.g.
+// for the collation id of text columns
+func (event *RowsEvent) Columns() ([]Column) {
+	return event.tableMap.Columns()
+}
+
+// ExtraData returns the extra data block of v2 rows events, without its length.
+// It starts with a type byte, e.g. 0 for NDB info or 1 for partition info.
+func (event *RowsEvent) ExtraData() ([]byte) {
+	return event.extraData
+}
+
+// RawColumns returns the undecoded bytes of every column of every row when the
+// event was parsed in raw mode, and nil otherwise. The slices point into the
+// event's packet. The JSON columns of PARTIAL_UPDATE_ROWS_EVENT after images
+// may hold diffs instead of a document.
+func (event *RowsEvent) RawColumns() ([][][]byte) {
+	return event.rawRows
+}
+
+func (event *RowsEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *RowsEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "tableId: %v, flags: %v, extraData: %x, columnsPresentBitmap1: %x, columnsPresentBitmap2: %x\n",
+	            event.tableId, event.flags, event.extraData, event.columnsPresentBitmap1, event.columnsPresentBitmap2)
+
+	for i, row := range event.rawRows {
+		fmt.Fprintf(w, "row[%d]:\n", i)
+		for _, col := range row {
+			fmt.Fprintf(w, "  %x\n", col)
+		}
+	}
+
+	tableMap := event.tableMap
+	for i, row := range event.rows {
+		fmt.Fprintf(w, "row[%d]:\n", i)
+		for j, col := range row {
+			colType := tableMap.columnTypes[j]
+			typeName := fieldTypeName(colType)
+			switch colType {
+			case FIELD_TYPE_VARCHAR, FIELD_TYPE_VAR_STRING, FIELD_TYPE_STRING,
+			     FIELD_TYPE_BLOB, FIELD_TYPE_TINY_BLOB, FIELD_TYPE_MEDIUM_BLOB, FIELD_TYPE_LONG_BLOB, FIELD_TYPE_JSON:
+				fmt.Fprintf(w, "  %s: %q\n", typeName, col)
+			default:
+				fmt.Fprintf(w, "  %s: %v\n", typeName, col)
+			}
+		}
+	}
+}
+
+// MarshalJSON encodes the event with its table and rows. Rows are objects
+// keyed by column name if the table's columns are known (see RowMaps), arrays
+// otherwise, and update events list {"before": row, "after": row} pairs.
+// Values encode as encoding/json does, e.g. []byte values in base64: use
+// SetTextAsString to get text columns as strings.
+func (event *RowsEvent) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{
+		"tableId": event.tableId,
+		"schemaName": event.SchemaName(),
+		"tableName": event.TableName(),
+		"operation": event.Operation().String(),
+		"flags": event.flags,
+	}
+	if len(event.extraData) > 0 {
+		fields["extraData"] = event.extraData
+	}
+	if event.rawRows != nil {
+		fields["rawRows"] = event.rawRows
+	}
+	if event.Filtered() {
+		fields["filtered"] = true
+	}
+
+	rows := make([]interface{}, 0, len(event.rows))
+	if maps := event.RowMaps(); maps != nil {
+		for _, row := range maps {
+			rows = append(rows, row)
+		}
+	} else {
+		for _, row := range event.rows {
+			rows = append(rows, row)
+		}
+	}
+	if event.Operation() == ROWS_UPDATE {
+		pairs := make([]interface{}, 0, len(rows) / 2)
+		for i := 0; i + 1 < len(rows); i += 2 {
+			pairs = append(pairs, map[string]interface{}{"before": rows[i], "after": rows[i + 1]})
+		}
+		rows = pairs
+	}
+	fields["rows"] = rows
+
+	return marshalEvent(&event.header, fields)
+}
+
+
+type TableMapEvent struct {
+	header EventHeader
+	tableId uint64
+	flags uint16
+	schemaName string
+	tableName string
+	columnTypes []FieldType
+	columnMeta []uint16
+	nullBitmap Bitfield
+	columns []Column
+	decoders []CharsetDecoder // see lookupCharsetDecoders
+	filtered bool // rows events of the table are skipped
+}
+
+// Collation id of the binary character set
+const CHARSET_BINARY = 63
+
+// Column describes a table column as declared in its CREATE TABLE. Table map
+// events only carry column types, unless MySQL 8.0 logs them with optional
+// metadata (binlog_row_metadata), so anything else the row decoder needs to
+// know has to be registered with the parser's RegisterTable.
+type Column struct {
+	Name string
+	Charset uint16 // collation id, CHARSET_BINARY for BINARY/VARBINARY/BLOB
+	PrimaryKey bool
+	Unsigned bool // integer columns are returned as uint64 instead of int64
+	EnumValues []string // ENUM labels in declaration order
+	SetValues []string // SET members in declaration order
+	// Bytes of the values of a pre-5.0 DECIMAL(M,D) column, M + 2 for its
+	// sign and point, see readOldDecimal
+	DecimalLength int
+}
+
+// Returns the registered definition of column i, or an empty one
+func (event *TableMapEvent) column(i int) (Column) {
+	if i < len(event.columns) {
+		return event.columns[i]
+	}
+	return Column{}
+}
+
+// Reads a SET value, a little-endian bitmask of size (1-8) bytes where bit n
+// stands for the set's n+1th member. If the set's members are known the value
+// is returned as MySQL shows it, the members joined by commas, else the
+// bitmask as uint64.
+func readSet(buf *bytes.Buffer, size int, members []string) (value driver.Value, e error) {
+	if size < 1 || size > 8 {
+		return nil, fmt.Errorf("Invalid SET size %d", size)
+	}
+	mask, e := readFixedLengthInteger(buf, size)
+	if e != nil {
+		return nil, e
+	}
+	if members == nil {
+		return mask, nil
+	}
+	return strings.Join(SetMembers(mask, members), ","), nil
+}
+
+// SetMembers returns the members of a decoded SET value, given the set's
+// member names in declaration order
+func SetMembers(mask uint64, members []string) (active []string) {
+	for i, member := range members {
+		if i < 64 && mask & (1 << uint(i)) != 0 {
+			active = append(active, member)
+		}
+	}
+	return
+}
+
+// Returns whether column i is registered with the binary character set
+func (event *TableMapEvent) isBinary(i int) bool {
+	return event.column(i).Charset == CHARSET_BINARY
+}
+
+// Reads an ENUM value, stored as its 1-based index in size (1 or 2) bytes. If
+// the enum's labels are known the label is returned, else the index as int64.
+// Index 0 is the empty string MySQL stores for invalid values.
+func readEnum(buf *bytes.Buffer, size int, labels []string) (value driver.Value, e error) {
+	if size != 1 && size != 2 {
+		return nil, fmt.Errorf("Invalid ENUM size %d", size)
+	}
+	index, e := readFixedLengthInteger(buf, size)
+	if e != nil {
+		return nil, e
+	}
+	switch {
+	case labels == nil || index > uint64(len(labels)):
+		return int64(index), nil
+	case index == 0:
+		return "", nil
+	}
+	return labels[index - 1], nil
+}
+
+// Reads a pre-5.0 DECIMAL value, which tables created before 5.0 still hold
+// until they're altered. It's stored as the ASCII text of the number,
+// right-aligned in length bytes, e.g. "  12.50" for DECIMAL(5,2). The server
+// logs no meta for it, so length has to be registered with the column, see
+// Column.DecimalLength. Returns the number as a string, like NEWDECIMAL.
+func readOldDecimal(buf *bytes.Buffer, column int, length uint16) (value driver.Value, e error) {
+	if length == 0 {
+		return nil, fmt.Errorf("Unknown length of pre-5.0 DECIMAL column %d, see Column.DecimalLength", column)
+	}
+	data, e := readBytes(buf, int(length))
+	if e != nil {
+		return nil, e
+	}
+	text := strings.TrimPrefix(strings.TrimLeft(string(data), " "), "+")
+	digits := strings.TrimPrefix(text, "-")
+	if digits == "" || strings.Trim(digits, "0123456789.") != "" || strings.Count(digits, ".") > 1 {
+		return nil, fmt.Errorf("Invalid DECIMAL value %q in column %d", data, column)
+	}
+	return text, nil
+}
+
+// STRING columns pack their real type (STRING, ENUM or SET) and their maximum
+// length in bytes into the column meta. Lengths above 255 borrow two bits of
+// the type byte, inverted.
+func stringFieldInfo(meta uint16) (realType FieldType, maxLength int) {
+	typeByte := byte(meta)
+	lengthByte := int(meta >> 8)
+	if typeByte == 0 {
+		return FIELD_TYPE_STRING, lengthByte
+	}
+	if typeByte & 0x30 != 0x30 {
+		return FieldType(typeByte | 0x30), lengthByte | int((typeByte & 0x30) ^ 0x30) << 4
+	}
+	return FieldType(typeByte), lengthByte
+}
+
+func (event *TableMapEvent) parseColumnMetadata(data []byte) (error) {
+	pos := 0
+	event.columnMeta = make([]uint16, len(event.columnTypes))
+	for i, t := range event.columnTypes {
+		switch t {
+		case FIELD_TYPE_STRING,
+		     FIELD_TYPE_VAR_STRING,
+		     FIELD_TYPE_VARCHAR,
+		     FIELD_TYPE_NEWDECIMAL,
+		     FIELD_TYPE_ENUM,
+		     FIELD_TYPE_SET,
+		     FIELD_TYPE_BIT:
+			if len(data) < pos + 2 {
+				return &ErrTruncatedEvent{ReadSize: 2, Remaining: len(data) - pos}
+			}
+			event.columnMeta[i] = bytesToUint16(data[pos:pos+2])
+			pos += 2
+			// Precision and scale size the values, see decimalBinarySize
+			precision, scale := event.columnMeta[i] & 0xff, event.columnMeta[i] >> 8
+			if t == FIELD_TYPE_NEWDECIMAL && (precision < 1 || scale > precision) {
+				return fmt.Errorf("Invalid DECIMAL(%d,%d) metadata for column %d", precision, scale, i)
+			}
+
 		case FIELD_TYPE_BLOB,
 		     FIELD_TYPE_DOUBLE,
 		     FIELD_TYPE_FLOAT,
-		     FIELD_TYPE_GEOMETRY:
+		     FIELD_TYPE_GEOMETRY,
+		     FIELD_TYPE_JSON,
+		     FIELD_TYPE_TIMESTAMP2,
+		     FIELD_TYPE_DATETIME2,
+		     FIELD_TYPE_TIME2:
+			if len(data) < pos + 1 {
+				return &ErrTruncatedEvent{ReadSize: 1, Remaining: len(data) - pos}
+			}
 			event.columnMeta[i] = uint16(data[pos])
 			pos += 1
 
-		case FIELD_TYPE_BIT,
+		case FIELD_TYPE_DECIMAL,
 		     FIELD_TYPE_DATE,
 		     FIELD_TYPE_DATETIME,
 		     FIELD_TYPE_TIMESTAMP,
@@ -454,12 +2139,218 @@ func (event *TableMapEvent) parseColumnMetadata(data []byte) (error) {
 			event.columnMeta[i] = 0
 
 		default:
-			return fmt.Errorf("Unknown FieldType %d", t)
+			return &ErrUnsupportedFieldType{Type: t, Column: i}
 		}
 	}
 	return nil
 }
 
+// Optional metadata field types of table map events, logged by MySQL 8.0.1+
+// as binlog_row_metadata asks for: MINIMAL logs signedness, charsets and
+// geometry types, FULL logs everything.
+const (
+	TABLE_MAP_SIGNEDNESS = iota + 1
+	TABLE_MAP_DEFAULT_CHARSET
+	TABLE_MAP_COLUMN_CHARSET
+	TABLE_MAP_COLUMN_NAME
+	TABLE_MAP_SET_STR_VALUE
+	TABLE_MAP_ENUM_STR_VALUE
+	TABLE_MAP_GEOMETRY_TYPE
+	TABLE_MAP_SIMPLE_PRIMARY_KEY
+	TABLE_MAP_PRIMARY_KEY_WITH_PREFIX
+	TABLE_MAP_ENUM_AND_SET_DEFAULT_CHARSET
+	TABLE_MAP_ENUM_AND_SET_COLUMN_CHARSET
+	TABLE_MAP_COLUMN_VISIBILITY
+)
+
+/* Optional metadata, following the null bitmap. A sequence of fields:
+Bytes                        Name
+-----                        ----
+1                            field type
+1-9 (Length Coded Binary)    field length
+n                            field value
+
+Fields about some kind of column (e.g. signedness of numeric columns) have an
+entry for each column of that kind, in table order. Integers in field values
+are length coded binary.
+*/
+func (event *TableMapEvent) parseOptionalMetadata(data []byte) (columns []Column, err error) {
+	columns = make([]Column, len(event.columnTypes))
+	numeric := event.columnsWhere(isNumericType)
+	character := event.columnsWhere(isCharacterType)
+	enumOrSet := event.columnsWhere(func(t FieldType) bool { return t == FIELD_TYPE_ENUM || t == FIELD_TYPE_SET })
+
+	buf := bytes.NewBuffer(data)
+	for buf.Len() > 0 {
+		var fieldType byte
+		var length uint64
+		var field []byte
+		if fieldType, err = buf.ReadByte(); err != nil {
+			return
+		}
+		if length, _, err = readLengthEncodedInt(buf); err != nil {
+			return
+		}
+		if field, err = readBytes(buf, int(length)); err != nil {
+			return
+		}
+		fieldBuf := bytes.NewBuffer(field)
+
+		switch fieldType {
+		case TABLE_MAP_SIGNEDNESS:
+			// A bitmap, most significant bit first, set for unsigned columns
+			if len(field) < (len(numeric) + 7) / 8 {
+				return nil, io.EOF
+			}
+			for j, i := range numeric {
+				columns[i].Unsigned = field[j / 8] & (0x80 >> uint(j % 8)) != 0
+			}
+
+		case TABLE_MAP_DEFAULT_CHARSET, TABLE_MAP_ENUM_AND_SET_DEFAULT_CHARSET:
+			// The most common collation, then the index and collation of the
+			// columns using another one
+			indexes := character
+			if fieldType == TABLE_MAP_ENUM_AND_SET_DEFAULT_CHARSET {
+				indexes = enumOrSet
+			}
+			var charset, j uint64
+			if charset, _, err = readLengthEncodedInt(fieldBuf); err != nil {
+				return
+			}
+			for _, i := range indexes {
+				columns[i].Charset = uint16(charset)
+			}
+			for fieldBuf.Len() > 0 {
+				if j, _, err = readLengthEncodedInt(fieldBuf); err != nil {
+					return
+				}
+				if charset, _, err = readLengthEncodedInt(fieldBuf); err != nil {
+					return
+				}
+				if j >= uint64(len(indexes)) {
+					return nil, fmt.Errorf("Invalid charset column index %d", j)
+				}
+				columns[indexes[j]].Charset = uint16(charset)
+			}
+
+		case TABLE_MAP_COLUMN_CHARSET, TABLE_MAP_ENUM_AND_SET_COLUMN_CHARSET:
+			indexes := character
+			if fieldType == TABLE_MAP_ENUM_AND_SET_COLUMN_CHARSET {
+				indexes = enumOrSet
+			}
+			for _, i := range indexes {
+				var charset uint64
+				if charset, _, err = readLengthEncodedInt(fieldBuf); err != nil {
+					return
+				}
+				columns[i].Charset = uint16(charset)
+			}
+
+		case TABLE_MAP_COLUMN_NAME:
+			for i := range columns {
+				var name []byte
+				if name, err = readLengthEncodedBytes(fieldBuf); err != nil {
+					return
+				}
+				columns[i].Name = string(name)
+			}
+
+		case TABLE_MAP_SET_STR_VALUE, TABLE_MAP_ENUM_STR_VALUE:
+			// For each column the number of labels, then the labels
+			wantType := FIELD_TYPE_SET
+			if fieldType == TABLE_MAP_ENUM_STR_VALUE {
+				wantType = FIELD_TYPE_ENUM
+			}
+			for _, i := range enumOrSet {
+				if event.ColumnMeta(i).Type != wantType {
+					continue
+				}
+				var count uint64
+				if count, _, err = readLengthEncodedInt(fieldBuf); err != nil {
+					return
+				}
+				// Every label takes at least its length byte, don't let a
+				// corrupt count allocate more than the field holds
+				if count > uint64(fieldBuf.Len()) {
+					return nil, io.EOF
+				}
+				labels := make([]string, 0, count)
+				for ; count > 0; count-- {
+					var label []byte
+					if label, err = readLengthEncodedBytes(fieldBuf); err != nil {
+						return
+					}
+					labels = append(labels, string(label))
+				}
+				if wantType == FIELD_TYPE_ENUM {
+					columns[i].EnumValues = labels
+				} else {
+					columns[i].SetValues = labels
+				}
+			}
+
+		case TABLE_MAP_SIMPLE_PRIMARY_KEY, TABLE_MAP_PRIMARY_KEY_WITH_PREFIX:
+			// Column indexes, each followed by the prefix length (0 for the
+			// whole column) in PRIMARY_KEY_WITH_PREFIX
+			for fieldBuf.Len() > 0 {
+				var i uint64
+				if i, _, err = readLengthEncodedInt(fieldBuf); err != nil {
+					return
+				}
+				if fieldType == TABLE_MAP_PRIMARY_KEY_WITH_PREFIX {
+					if _, _, err = readLengthEncodedInt(fieldBuf); err != nil {
+						return
+					}
+				}
+				if i >= uint64(len(columns)) {
+					return nil, fmt.Errorf("Invalid primary key column index %d", i)
+				}
+				columns[i].PrimaryKey = true
+			}
+		}
+		// Other fields, e.g. geometry types, are skipped
+	}
+	return
+}
+
+// Returns the indexes of the columns whose real type (see ColumnMeta) matches
+func (event *TableMapEvent) columnsWhere(match func(FieldType) bool) (indexes []int) {
+	for i := range event.columnTypes {
+		if match(event.ColumnMeta(i).Type) {
+			indexes = append(indexes, i)
+		}
+	}
+	return
+}
+
+// Columns whose signedness the optional metadata logs
+func isNumericType(t FieldType) bool {
+	switch t {
+	case FIELD_TYPE_TINY, FIELD_TYPE_SHORT, FIELD_TYPE_INT24, FIELD_TYPE_LONG, FIELD_TYPE_LONGLONG,
+	     FIELD_TYPE_FLOAT, FIELD_TYPE_DOUBLE, FIELD_TYPE_NEWDECIMAL:
+		return true
+	}
+	return false
+}
+
+// Columns whose charset the optional metadata logs, besides ENUM and SET
+func isCharacterType(t FieldType) bool {
+	switch t {
+	case FIELD_TYPE_STRING, FIELD_TYPE_VAR_STRING, FIELD_TYPE_VARCHAR, FIELD_TYPE_BLOB:
+		return true
+	}
+	return false
+}
+
+// Reads a string prefixed by its length as a length coded binary
+func readLengthEncodedBytes(buf *bytes.Buffer) ([]byte, error) {
+	length, _, e := readLengthEncodedInt(buf)
+	if e != nil {
+		return nil, e
+	}
+	return readBytes(buf, int(length))
+}
+
 func (parser *eventParser) parseTableMapEvent(buf *bytes.Buffer) (event *TableMapEvent, err error) {
 	var byteLength byte
 	var columnCount, variableLength uint64
@@ -470,40 +2361,66 @@ func (parser *eventParser) parseTableMapEvent(buf *bytes.Buffer) (event *TableMa
 		return
 	}
 
-	headerSize := parser.format.eventTypeHeaderLengths[event.header.EventType - 1]
+	headerSize := parser.format.HeaderLength(event.header.EventType)
 	var tableIdSize int
 	if headerSize == 6 {
 		tableIdSize = 4
 	} else {
 		tableIdSize = 6
 	}
-	event.tableId, err = readFixedLengthInteger(buf, tableIdSize)
+	if event.tableId, err = readFixedLengthInteger(buf, tableIdSize); err != nil {
+		return
+	}
 
-	err = binary.Read(buf, binary.LittleEndian, &event.flags)
-	byteLength, err = buf.ReadByte()
-	event.schemaName = string(buf.Next(int(byteLength)))
-	_, err = buf.ReadByte()
-	byteLength, err = buf.ReadByte()
-	event.tableName = string(buf.Next(int(byteLength)))
-	_, err = buf.ReadByte()
+	if err = binary.Read(buf, binary.LittleEndian, &event.flags); err != nil {
+		return
+	}
+	var data []byte
+	if byteLength, err = buf.ReadByte(); err != nil {
+		return
+	}
+	if data, err = readBytes(buf, int(byteLength) + 1); err != nil {
+		return
+	}
+	event.schemaName = string(data[:byteLength])
+	if byteLength, err = buf.ReadByte(); err != nil {
+		return
+	}
+	if data, err = readBytes(buf, int(byteLength) + 1); err != nil {
+		return
+	}
+	event.tableName = string(data[:byteLength])
 
-	columnCount, _, err = readLengthEncodedInt(buf)
+	if columnCount, _, err = readLengthEncodedInt(buf); err != nil {
+		return
+	}
+	var columnData []byte
+	if columnData, err = readBytes(buf, int(columnCount)); err != nil {
+		return
+	}
 	event.columnTypes = make([]FieldType, columnCount)
-	columnData := buf.Next(int(columnCount))
 	for i, b := range columnData {
 		event.columnTypes[i] = FieldType(b)
 	}
 
-	variableLength, _, err = readLengthEncodedInt(buf)
-	if err = event.parseColumnMetadata(buf.Next(int(variableLength))); err != nil {
+	if variableLength, _, err = readLengthEncodedInt(buf); err != nil {
+		return
+	}
+	if data, err = readBytes(buf, int(variableLength)); err != nil {
+		return
+	}
+	if err = event.parseColumnMetadata(data); err != nil {
 		return
 	}
 
-	if buf.Len() < int((columnCount + 7) / 8) {
-		err = io.EOF
+	if data, err = readBytes(buf, int((columnCount + 7) / 8)); err != nil {
+		return
 	}
-	event.nullBitmap = Bitfield(buf.Next(int((columnCount + 7) / 8)))
+	event.nullBitmap = Bitfield(data)
 
+	if buf.Len() > 0 {
+		event.columns, err = event.parseOptionalMetadata(buf.Bytes())
+	}
 	return
 }
 
@@ -511,10 +2428,167 @@ func (event *TableMapEvent) Header() (*EventHeader) {
 	return &event.header
 }
 
+// TableId returns the id rows events refer to the table by, which is only
+// valid until the table's definition changes or the server restarts
+func (event *TableMapEvent) TableId() (uint64) {
+	return event.tableId
+}
+
+func (event *TableMapEvent) SchemaName() (string) {
+	return event.schemaName
+}
+
+func (event *TableMapEvent) TableName() (string) {
+	return event.tableName
+}
+
+// ColumnCount returns the number of columns of the table
+func (event *TableMapEvent) ColumnCount() (int) {
+	return len(event.columnTypes)
+}
+
+func (event *TableMapEvent) ColumnTypes() ([]FieldType) {
+	return event.columnTypes
+}
+
+// ColumnMeta is the type metadata of a column in a table map, decoded
+// according to its type. Fields that don't apply to the type are zero.
+type ColumnMeta struct {
+	Type FieldType // real type, e.g. FIELD_TYPE_ENUM for ENUM columns logged as FIELD_TYPE_STRING
+	MaxLength int // CHAR, VARCHAR, BINARY, VARBINARY: maximum length in bytes
+	LengthSize int // BLOB, TEXT, JSON, GEOMETRY: size of the length prefix, 1 for TINYBLOB to 4 for LONGBLOB
+	Size int // ENUM, SET, FLOAT, DOUBLE: storage size in bytes
+	Precision int // DECIMAL
+	Scale int // DECIMAL
+	FSP int // TIMESTAMP, DATETIME, TIME: fractional seconds precision
+	Bits int // BIT
+}
+
+// ColumnMeta returns the decoded metadata of column i
+func (event *TableMapEvent) ColumnMeta(i int) (ColumnMeta) {
+	t := event.columnTypes[i]
+	meta := event.columnMeta[i]
+	m := ColumnMeta{Type: t}
+	switch t {
+	case FIELD_TYPE_STRING:
+		m.Type, m.MaxLength = stringFieldInfo(meta)
+		if m.Type == FIELD_TYPE_ENUM || m.Type == FIELD_TYPE_SET {
+			m.Size, m.MaxLength = m.MaxLength, 0
+		}
+	case FIELD_TYPE_VARCHAR, FIELD_TYPE_VAR_STRING:
+		m.MaxLength = int(meta)
+	case FIELD_TYPE_ENUM, FIELD_TYPE_SET:
+		m.Size = int(meta >> 8)
+	case FIELD_TYPE_FLOAT, FIELD_TYPE_DOUBLE:
+		m.Size = int(meta)
+	case FIELD_TYPE_BLOB, FIELD_TYPE_TINY_BLOB, FIELD_TYPE_MEDIUM_BLOB, FIELD_TYPE_LONG_BLOB,
+	     FIELD_TYPE_GEOMETRY, FIELD_TYPE_JSON:
+		m.LengthSize = int(blobLengthSize(t, meta))
+	case FIELD_TYPE_NEWDECIMAL:
+		m.Precision = int(meta & 0xff)
+		m.Scale = int(meta >> 8)
+	case FIELD_TYPE_TIMESTAMP2, FIELD_TYPE_DATETIME2, FIELD_TYPE_TIME2:
+		m.FSP = int(meta)
+	case FIELD_TYPE_BIT:
+		m.Bits = int(meta >> 8) * 8 + int(meta & 0xff)
+	}
+	return m
+}
+
+// Columns returns the column definitions registered with RegisterTable or,
+// failing that, decoded from the event's optional metadata. It returns nil if
+// there are neither.
+func (event *TableMapEvent) Columns() ([]Column) {
+	return event.columns
+}
+
+// ColumnNames returns the column names registered with RegisterTable or logged
+// in the optional metadata (binlog_row_metadata=FULL), or nil if there are
+// neither.
+func (event *TableMapEvent) ColumnNames() (names []string) {
+	named := false
+	names = make([]string, len(event.columns))
+	for i, column := range event.columns {
+		names[i] = column.Name
+		named = named || column.Name != ""
+	}
+	if !named {
+		return nil
+	}
+	return
+}
+
 func (event *TableMapEvent) Print() {
-	event.header.Print()
-	fmt.Printf("tableId: %v, flags: %v, schemaName: %v, tableName: %v, columnTypes: %v, columnMeta = %v, nullBitmap = %x\n",
-	           event.tableId, event.flags, event.schemaName, event.tableName, event.columnTypeNames(), event.columnMeta, event.nullBitmap)
+	event.PrintTo(os.Stdout)
+}
+
+func (event *TableMapEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "tableId: %v, flags: %v, schemaName: %v, tableName: %v, columnTypes: %v, columnMeta = %v, nullBitmap = %x\n",
+	            event.tableId, event.flags, event.schemaName, event.tableName, event.columnTypeNames(), event.columnMeta, event.nullBitmap)
+}
+
+func (event *TableMapEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"tableId": event.tableId,
+		"flags": event.flags,
+		"schemaName": event.schemaName,
+		"tableName": event.tableName,
+		"columnTypes": event.columnTypeNames(),
+		"columnMeta": event.columnMeta,
+		"columnNames": event.ColumnNames(),
+	})
+}
+
+// Field types parseEventRow can decode
+var supportedFieldTypes = []FieldType{
+	FIELD_TYPE_NULL,
+	FIELD_TYPE_TINY,
+	FIELD_TYPE_SHORT,
+	FIELD_TYPE_INT24,
+	FIELD_TYPE_LONG,
+	FIELD_TYPE_LONGLONG,
+	FIELD_TYPE_FLOAT,
+	FIELD_TYPE_DOUBLE,
+	FIELD_TYPE_DECIMAL,
+	FIELD_TYPE_NEWDECIMAL,
+	FIELD_TYPE_YEAR,
+	FIELD_TYPE_DATE,
+	FIELD_TYPE_NEWDATE,
+	FIELD_TYPE_TIMESTAMP,
+	FIELD_TYPE_TIMESTAMP2,
+	FIELD_TYPE_DATETIME,
+	FIELD_TYPE_DATETIME2,
+	FIELD_TYPE_TIME2,
+	FIELD_TYPE_BIT,
+	FIELD_TYPE_ENUM,
+	FIELD_TYPE_SET,
+	FIELD_TYPE_VARCHAR,
+	FIELD_TYPE_VAR_STRING,
+	FIELD_TYPE_STRING,
+	FIELD_TYPE_TINY_BLOB,
+	FIELD_TYPE_MEDIUM_BLOB,
+	FIELD_TYPE_LONG_BLOB,
+	FIELD_TYPE_BLOB,
+	FIELD_TYPE_GEOMETRY,
+	FIELD_TYPE_JSON,
+}
+
+// SupportedFieldTypes returns the column types rows events can be decoded for.
+// Rows events of tables with any other column type fail to parse.
+func SupportedFieldTypes() []FieldType {
+	types := make([]FieldType, len(supportedFieldTypes))
+	copy(types, supportedFieldTypes)
+	return types
+}
+
+func IsFieldTypeSupported(t FieldType) bool {
+	for _, supported := range supportedFieldTypes {
+		if t == supported {
+			return true
+		}
+	}
+	return false
 }
 
 func fieldTypeName(t FieldType) string {
@@ -536,6 +2610,9 @@ func fieldTypeName(t FieldType) string {
 	case FIELD_TYPE_NEWDATE: return "FIELD_TYPE_NEWDATE"
 	case FIELD_TYPE_VARCHAR: return "FIELD_TYPE_VARCHAR"
 	case FIELD_TYPE_BIT: return "FIELD_TYPE_BIT"
+	case FIELD_TYPE_TIMESTAMP2: return "FIELD_TYPE_TIMESTAMP2"
+	case FIELD_TYPE_DATETIME2: return "FIELD_TYPE_DATETIME2"
+	case FIELD_TYPE_TIME2: return "FIELD_TYPE_TIME2"
 	case FIELD_TYPE_NEWDECIMAL: return "FIELD_TYPE_NEWDECIMAL"
 	case FIELD_TYPE_ENUM: return "FIELD_TYPE_ENUM"
 	case FIELD_TYPE_SET: return "FIELD_TYPE_SET"
@@ -546,48 +2623,456 @@ func fieldTypeName(t FieldType) string {
 	case FIELD_TYPE_VAR_STRING: return "FIELD_TYPE_VAR_STRING"
 	case FIELD_TYPE_STRING: return "FIELD_TYPE_STRING"
 	case FIELD_TYPE_GEOMETRY: return "FIELD_TYPE_GEOMETRY"
+	case FIELD_TYPE_JSON: return "FIELD_TYPE_JSON"
 	}
 	return fmt.Sprintf("%d", t)
 }
 
-func (event *TableMapEvent) columnTypeNames() (names []string) {
-	names = make([]string, len(event.columnTypes))
-	for i, t := range event.columnTypes {
-		names[i] = fieldTypeName(t)
-	}
-	return
+func (event *TableMapEvent) columnTypeNames() (names []string) {
+	names = make([]string, len(event.columnTypes))
+	for i, t := range event.columnTypes {
+		names[i] = fieldTypeName(t)
+	}
+	return
+}
+
+
+type GTIDEvent struct {
+	header EventHeader
+	flags uint8
+	sid []byte
+	gno uint64
+	lastCommitted int64
+	sequenceNumber int64
+	immediateCommitTimestamp uint64
+	originalCommitTimestamp uint64
+	transactionLength uint64
+}
+
+/* GTID Event
+Bytes                        Name
+-----                        ----
+1                            flags
+16                           SID (server UUID)
+8                            GNO
+  MySQL 5.7+:
+1                            logical clock timestamp type
+8                            last_committed
+8                            sequence_number
+  MySQL 8.0.1+:
+7                            immediate_commit_timestamp (high bit: original follows)
+7                            original_commit_timestamp (optional)
+  MySQL 8.0.2+:
+1-9 (Length Coded Binary)    transaction_length
+*/
+func parseGTIDEvent(buf *bytes.Buffer) (event *GTIDEvent, err error) {
+	event = new(GTIDEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	if event.flags, err = buf.ReadByte(); err != nil {
+		return
+	}
+	if event.sid, err = readBytes(buf, 16); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &event.gno); err != nil {
+		return
+	}
+
+	// Older servers end the event here
+	if buf.Len() < 17 {
+		return
+	}
+	buf.Next(1)
+	if err = binary.Read(buf, binary.LittleEndian, &event.lastCommitted); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &event.sequenceNumber); err != nil {
+		return
+	}
+
+	if buf.Len() < 7 {
+		return
+	}
+	event.immediateCommitTimestamp, _ = readFixedLengthInteger(buf, 7)
+	event.originalCommitTimestamp = event.immediateCommitTimestamp
+	if event.immediateCommitTimestamp & (1 << 55) != 0 {
+		event.immediateCommitTimestamp &^= 1 << 55
+		if event.originalCommitTimestamp, err = readFixedLengthInteger(buf, 7); err != nil {
+			return
+		}
+	}
+
+	if buf.Len() < 1 {
+		return
+	}
+	event.transactionLength, _, err = readLengthEncodedInt(buf)
+	return
+}
+
+func (event *GTIDEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+// TransactionLength returns the size in bytes of the transaction this event
+// starts, the GTID event included, or 0 if the server didn't log it (before
+// MySQL 8.0.2).
+func (event *GTIDEvent) TransactionLength() (uint64) {
+	return event.transactionLength
+}
+
+// GTID returns the global transaction identifier as server UUID:number, or
+// an empty string for an AnonymousGTIDEvent
+func (event *GTIDEvent) GTID() (string) {
+	if event.header.EventType == ANONYMOUS_GTID_EVENT {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", formatUUID(event.sid), event.gno)
+}
+
+func (event *GTIDEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *GTIDEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "flags: %v, gtid: %s, lastCommitted: %v, sequenceNumber: %v, transactionLength: %v\n",
+	            event.flags, event.GTID(), event.lastCommitted, event.sequenceNumber, event.transactionLength)
+}
+
+func (event *GTIDEvent) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{
+		"flags": event.flags,
+		"gtid": event.GTID(),
+		"lastCommitted": event.lastCommitted,
+		"sequenceNumber": event.sequenceNumber,
+		"transactionLength": event.transactionLength,
+	}
+	// Microseconds since the epoch, only logged by MySQL 8.0.1+
+	if event.immediateCommitTimestamp != 0 {
+		fields["immediateCommitTimestamp"] = time.UnixMicro(int64(event.immediateCommitTimestamp)).UTC().Format(time.RFC3339Nano)
+		fields["originalCommitTimestamp"] = time.UnixMicro(int64(event.originalCommitTimestamp)).UTC().Format(time.RFC3339Nano)
+	}
+	return marshalEvent(&event.header, fields)
+}
+
+// AnonymousGTIDEvent starts a transaction that has no GTID, in place of a
+// GTID_EVENT, while gtid_mode isn't ON. Its body is laid out like a
+// GTID_EVENT's, with a zero SID and GNO, so the logical clock, commit
+// timestamps and transaction length are available the same way.
+type AnonymousGTIDEvent struct {
+	GTIDEvent
+}
+
+func parseAnonymousGTIDEvent(buf *bytes.Buffer) (*AnonymousGTIDEvent, error) {
+	event, err := parseGTIDEvent(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &AnonymousGTIDEvent{GTIDEvent: *event}, nil
+}
+
+func formatUUID(b []byte) string {
+	if len(b) != 16 {
+		return fmt.Sprintf("%x", b)
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+
+// GTIDInterval is a range of transaction numbers, both ends included
+type GTIDInterval struct {
+	Start uint64
+	End uint64
+}
+
+// UUIDSet holds the transactions executed by the server with the given UUID
+type UUIDSet struct {
+	SID string
+	Intervals []GTIDInterval
+}
+
+type GTIDSet []UUIDSet
+
+// Returns the set in the format used by MySQL, e.g. "uuid:1-100:200-300"
+func (set GTIDSet) String() string {
+	var buf bytes.Buffer
+	for i, uuidSet := range set {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(uuidSet.SID)
+		for _, interval := range uuidSet.Intervals {
+			if interval.Start == interval.End {
+				fmt.Fprintf(&buf, ":%d", interval.Start)
+			} else {
+				fmt.Fprintf(&buf, ":%d-%d", interval.Start, interval.End)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// ParseGTIDSet parses a GTID set in the format used by MySQL, e.g.
+// "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-100:200,...". Whitespace around the
+// UUID sets is ignored, as in the output of SELECT @@gtid_executed.
+func ParseGTIDSet(s string) (set GTIDSet, e error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if _, e = parseUUID(fields[0]); e != nil {
+			return nil, e
+		}
+		uuidSet := UUIDSet{SID: strings.ToLower(fields[0])}
+		for _, field := range fields[1:] {
+			var interval GTIDInterval
+			bounds := strings.SplitN(field, "-", 2)
+			if interval.Start, e = strconv.ParseUint(bounds[0], 10, 64); e != nil {
+				return nil, fmt.Errorf("Invalid GTID interval %q", field)
+			}
+			interval.End = interval.Start
+			if len(bounds) == 2 {
+				if interval.End, e = strconv.ParseUint(bounds[1], 10, 64); e != nil || interval.End < interval.Start {
+					return nil, fmt.Errorf("Invalid GTID interval %q", field)
+				}
+			}
+			uuidSet.Intervals = append(uuidSet.Intervals, interval)
+		}
+		set = append(set, uuidSet)
+	}
+	return set, nil
+}
+
+// Returns the 16 bytes of a UUID in its text form
+func parseUUID(s string) ([]byte, error) {
+	b, e := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if e != nil || len(b) != 16 {
+		return nil, fmt.Errorf("Invalid UUID %q", s)
+	}
+	return b, nil
+}
+
+// Encodes the set like PREVIOUS_GTIDS_EVENT and COM_BINLOG_DUMP_GTID do
+func (set GTIDSet) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(len(set)))
+	for _, uuidSet := range set {
+		sid, e := parseUUID(uuidSet.SID)
+		if e != nil {
+			return nil, e
+		}
+		buf.Write(sid)
+		binary.Write(&buf, binary.LittleEndian, uint64(len(uuidSet.Intervals)))
+		for _, interval := range uuidSet.Intervals {
+			binary.Write(&buf, binary.LittleEndian, interval.Start)
+			binary.Write(&buf, binary.LittleEndian, interval.End + 1)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+
+type PreviousGTIDsEvent struct {
+	header EventHeader
+	gtidSet GTIDSet
+}
+
+/* Previous GTIDs Event
+Bytes                        Name
+-----                        ----
+8                            number of SIDs
+  for each SID:
+16                           SID (server UUID)
+8                            number of intervals
+  for each interval:
+8                            start GNO
+8                            end GNO (exclusive)
+*/
+func parsePreviousGTIDsEvent(buf *bytes.Buffer) (event *PreviousGTIDsEvent, err error) {
+	event = new(PreviousGTIDsEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	var sidCount uint64
+	if err = binary.Read(buf, binary.LittleEndian, &sidCount); err != nil {
+		return
+	}
+	for i := uint64(0); i < sidCount; i++ {
+		var sid []byte
+		if sid, err = readBytes(buf, 16); err != nil {
+			return
+		}
+		uuidSet := UUIDSet{SID: formatUUID(sid)}
+		var intervalCount uint64
+		if err = binary.Read(buf, binary.LittleEndian, &intervalCount); err != nil {
+			return
+		}
+		for j := uint64(0); j < intervalCount; j++ {
+			var start, end uint64
+			if err = binary.Read(buf, binary.LittleEndian, &start); err != nil {
+				return
+			}
+			if err = binary.Read(buf, binary.LittleEndian, &end); err != nil {
+				return
+			}
+			// end is exclusive
+			if end <= start {
+				err = fmt.Errorf("Empty GTID interval [%d, %d) of %s", start, end, uuidSet.SID)
+				return
+			}
+			uuidSet.Intervals = append(uuidSet.Intervals, GTIDInterval{start, end - 1})
+		}
+		event.gtidSet = append(event.gtidSet, uuidSet)
+	}
+	return
+}
+
+func (event *PreviousGTIDsEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+// GTIDSet returns the transactions logged before the binlog file this event
+// starts
+func (event *PreviousGTIDsEvent) GTIDSet() (GTIDSet) {
+	return event.gtidSet
+}
+
+func (event *PreviousGTIDsEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *PreviousGTIDsEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "gtidSet: %s\n", event.gtidSet)
+}
+
+func (event *PreviousGTIDsEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"gtidSet": event.gtidSet.String(),
+	})
 }
 
 
 type BinlogEvent interface {
 	Header() (*EventHeader)
+	// Print writes a human readable dump of the event to stdout, and PrintTo
+	// to w.
 	Print()
+	PrintTo(w io.Writer)
+	// MarshalJSON encodes the event as a JSON object with the header fields
+	// under "header", timestamps in RFC 3339
+	json.Marshaler
 }
 
+// Parses an event, header included. The event keeps references into data
+// instead of copying it, e.g. for column values or GenericEvent data, so data
+// must not be modified or reused afterwards. readPacket and Reader.Next
+// allocate a new buffer for every event, Parser.ParseEvent copies it.
 func (parser *eventParser) parseEvent(data []byte) (event BinlogEvent, err error) {
+	if len(data) < eventHeaderSize {
+		return nil, &ErrTruncatedEvent{ReadSize: eventHeaderSize, Remaining: len(data)}
+	}
+	if EventType(data[4]) != FORMAT_DESCRIPTION_EVENT && parser.ChecksumAlgorithm() == BINLOG_CHECKSUM_ALG_CRC32 {
+
+		if data, err = stripChecksum(data, parser.validateChecksum); err != nil {
+			return nil, err
+		}
+	}
+	return parser.parseEventWithoutChecksum(data)
+}
+
+// Parses an event whose checksum, if any, was stripped, header included
+func (parser *eventParser) parseEventWithoutChecksum(data []byte) (event BinlogEvent, err error) {
+	defer func() {
+		err = truncatedEventError(EventType(data[4]), err)
+	}()
 	buf := bytes.NewBuffer(data)
 
-	switch(eventType(data[4])) {
+	switch(EventType(data[4])) {
 	case FORMAT_DESCRIPTION_EVENT:
-		parser.format, err = parseFormatDescriptionEvent(buf)
-		event = parser.format
+		var format *FormatDescriptionEvent
+		if format, err = parseFormatDescriptionEvent(buf); err != nil {
+			return
+		}
+		parser.format = format
+		parser.resetTableMaps()
+		parser.log().Debugf("Format description of server %s, binlog version %d", strings.TrimRight(format.mysqlServerVersion, "\x00"), format.binlogVersion)
+		event = format
 		return
 	case QUERY_EVENT:
 		return parseQueryEvent(buf)
+	case EXECUTE_LOAD_QUERY_EVENT:
+		return parseExecuteLoadQueryEvent(buf)
 	case ROTATE_EVENT:
+		parser.resetTableMaps()
 		return parseRotateEvent(buf)
+	case HEARTBEAT_EVENT:
+		return parseHeartbeatEvent(buf)
+	case XID_EVENT:
+		return parseXIDEvent(buf)
+	case INTVAR_EVENT:
+		return parseIntVarEvent(buf)
+	case ROWS_QUERY_EVENT:
+		return parseRowsQueryEvent(buf)
+	case STOP_EVENT:
+		return parseStopEvent(buf)
+	case INCIDENT_EVENT:
+		return parseIncidentEvent(buf)
 	case TABLE_MAP_EVENT:
 		var table_map_event *TableMapEvent
-		table_map_event, err = parser.parseTableMapEvent(buf)
-		parser.tableMap[table_map_event.tableId] = table_map_event
+		if table_map_event, err = parser.parseTableMapEvent(buf); err != nil {
+			return
+		}
+		// Registered definitions take precedence over the optional metadata
+		columns := parser.tables[table_map_event.schemaName + "." + table_map_event.tableName]
+		if columns != nil && len(columns) != len(table_map_event.columnTypes) {
+			parser.log().Infof("Ignoring the %d columns registered for %s.%s, which has %d",
+				len(columns), table_map_event.schemaName, table_map_event.tableName, len(table_map_event.columnTypes))
+		}
+		if len(columns) == len(table_map_event.columnTypes) {
+			table_map_event.columns = columns
+			// The server logs no meta for pre-5.0 DECIMAL columns, which
+			// holds their length instead
+			for i, column := range columns {
+				if table_map_event.columnTypes[i] == FIELD_TYPE_DECIMAL {
+					table_map_event.columnMeta[i] = uint16(column.DecimalLength)
+				}
+			}
+		}
+		table_map_event.decoders = lookupCharsetDecoders(table_map_event)
+		if parser.tableFilter != nil {
+			table_map_event.filtered = !parser.tableFilter(table_map_event.schemaName, table_map_event.tableName)
+		}
+		parser.addTableMap(table_map_event)
 		event = table_map_event
 		return
-	case WRITE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv1, DELETE_ROWS_EVENTv1:
+	case WRITE_ROWS_EVENTv0, UPDATE_ROWS_EVENTv0, DELETE_ROWS_EVENTv0,
+	     WRITE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv1, DELETE_ROWS_EVENTv1,
+	     WRITE_ROWS_EVENTv2, UPDATE_ROWS_EVENTv2, DELETE_ROWS_EVENTv2,
+	     PARTIAL_UPDATE_ROWS_EVENT:
 		return parser.parseRowsEvent(buf)
-	default:
-		return parseGenericEvent(buf)
+	case GTID_EVENT:
+		return parseGTIDEvent(buf)
+	case ANONYMOUS_GTID_EVENT:
+		return parseAnonymousGTIDEvent(buf)
+	case TRANSACTION_PAYLOAD_EVENT:
+		return parser.parseTransactionPayloadEvent(buf)
+	case PREVIOUS_GTIDS_EVENT:
+		return parsePreviousGTIDsEvent(buf)
 	}
-	return
+	if parser.Flavor() == FLAVOR_MARIADB {
+		switch EventType(data[4]) {
+		case MARIADB_GTID_EVENT:
+			return parseMariaDBGTIDEvent(buf)
+		case MARIADB_GTID_LIST_EVENT:
+			return parseMariaDBGTIDListEvent(buf)
+		}
+	}
+	return parseGenericEvent(buf)
 }
 
 func (header *EventHeader) Read(data []byte) (error) {
@@ -596,7 +3081,13 @@ func (header *EventHeader) Read(data []byte) (error) {
 }
 
 func (header *EventHeader) EventName() (string) {
-	switch header.EventType {
+	return header.EventType.String()
+}
+
+// String returns the name of the event type, e.g. "WRITE_ROWS_EVENTv2", or
+// its number if it's unknown
+func (t EventType) String() (string) {
+	switch t {
 	case UNKNOWN_EVENT:
 		return "UNKNOWN_EVENT"
 	case START_EVENT_V3:
@@ -669,8 +3160,42 @@ func (header *EventHeader) EventName() (string) {
 		return "ANONYMOUS_GTID_EVENT"
 	case PREVIOUS_GTIDS_EVENT:
 		return "PREVIOUS_GTIDS_EVENT"
+	case TRANSACTION_CONTEXT_EVENT:
+		return "TRANSACTION_CONTEXT_EVENT"
+	case VIEW_CHANGE_EVENT:
+		return "VIEW_CHANGE_EVENT"
+	case XA_PREPARE_LOG_EVENT:
+		return "XA_PREPARE_LOG_EVENT"
+	case PARTIAL_UPDATE_ROWS_EVENT:
+		return "PARTIAL_UPDATE_ROWS_EVENT"
+	case TRANSACTION_PAYLOAD_EVENT:
+		return "TRANSACTION_PAYLOAD_EVENT"
+	case MARIADB_ANNOTATE_ROWS_EVENT:
+		return "MARIADB_ANNOTATE_ROWS_EVENT"
+	case MARIADB_BINLOG_CHECKPOINT_EVENT:
+		return "MARIADB_BINLOG_CHECKPOINT_EVENT"
+	case MARIADB_GTID_EVENT:
+		return "MARIADB_GTID_EVENT"
+	case MARIADB_GTID_LIST_EVENT:
+		return "MARIADB_GTID_LIST_EVENT"
+	case MARIADB_START_ENCRYPTION_EVENT:
+		return "MARIADB_START_ENCRYPTION_EVENT"
+	case MARIADB_QUERY_COMPRESSED_EVENT:
+		return "MARIADB_QUERY_COMPRESSED_EVENT"
+	case MARIADB_WRITE_ROWS_COMPRESSED_EVENTv1:
+		return "MARIADB_WRITE_ROWS_COMPRESSED_EVENTv1"
+	case MARIADB_UPDATE_ROWS_COMPRESSED_EVENTv1:
+		return "MARIADB_UPDATE_ROWS_COMPRESSED_EVENTv1"
+	case MARIADB_DELETE_ROWS_COMPRESSED_EVENTv1:
+		return "MARIADB_DELETE_ROWS_COMPRESSED_EVENTv1"
+	case MARIADB_WRITE_ROWS_COMPRESSED_EVENT:
+		return "MARIADB_WRITE_ROWS_COMPRESSED_EVENT"
+	case MARIADB_UPDATE_ROWS_COMPRESSED_EVENT:
+		return "MARIADB_UPDATE_ROWS_COMPRESSED_EVENT"
+	case MARIADB_DELETE_ROWS_COMPRESSED_EVENT:
+		return "MARIADB_DELETE_ROWS_COMPRESSED_EVENT"
 	}
-	return fmt.Sprintf("%d", header.EventType)
+	return fmt.Sprintf("%d", byte(t))
 }
 
 func (header *EventHeader) FlagNames() (names []string) {
@@ -704,58 +3229,847 @@ func (header *EventHeader) FlagNames() (names []string) {
 	if (header.Flags & LOG_EVENT_MTS_ISOLATE_F != 0) {
 		names = append(names, "LOG_EVENT_MTS_ISOLATE_F")
 	}
-	if (header.Flags & ^(LOG_EVENT_MTS_ISOLATE_F << 1 - 1) != 0) { // unknown flags
-		names = append(names, string(header.Flags & ^(LOG_EVENT_MTS_ISOLATE_F << 1 - 1)))
+	if unknown := header.Flags & ^(LOG_EVENT_MTS_ISOLATE_F << 1 - 1); unknown != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint16(unknown)))
 	}
 	return names
 }
 
 func (header *EventHeader) Print() {
-	fmt.Printf("Timestamp: %v, EventType: %v, ServerId: %v, EventSize: %v, LogPos: %v, Flags: %v\n",
-	          time.Unix(int64(header.Timestamp), 0), header.EventName(), header.ServerId, header.EventSize, header.LogPos, header.FlagNames())
+	header.PrintTo(os.Stdout)
+}
+
+func (header *EventHeader) PrintTo(w io.Writer) {
+	fmt.Fprintln(w, header.String())
+}
+
+// String returns the one line summary of the header Print writes
+func (header *EventHeader) String() string {
+	return fmt.Sprintf("Timestamp: %v, EventType: %v, ServerId: %v, EventSize: %v, LogPos: %v, Flags: %v",
+	                   time.Unix(int64(header.Timestamp), 0), header.EventName(), header.ServerId, header.EventSize, header.LogPos, header.FlagNames())
+}
+
+func (header *EventHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"timestamp": formatJSONTimestamp(header.Timestamp),
+		"eventType": header.EventName(),
+		"serverId": header.ServerId,
+		"eventSize": header.EventSize,
+		"logPos": header.LogPos,
+		"flags": append([]string{}, header.FlagNames()...),
+	})
+}
+
+// Encodes an event as a JSON object of its event specific fields plus a
+// "header" field
+func marshalEvent(header *EventHeader, fields map[string]interface{}) ([]byte, error) {
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["header"] = header
+	return json.Marshal(fields)
+}
+
+// Formats seconds since the epoch as RFC 3339, in UTC
+func formatJSONTimestamp(seconds uint32) string {
+	return time.Unix(int64(seconds), 0).UTC().Format(time.RFC3339)
 }
 
 
 type eventParser struct {
 	format *FormatDescriptionEvent
 	tableMap map[uint64]*TableMapEvent
+	oldTableMap map[uint64]*TableMapEvent
+	tableMapLock sync.Mutex // guards both generations, see TableMaps
+	tableMapCacheSize int
+	tables map[string][]Column
+	rawMode bool
+	textAsString bool
+	location *time.Location
+	tableFilter func(schema, table string) bool
+	validateChecksum bool
+	checksumAlgorithm uint8 // see SetChecksumAlgorithm
+	flavor Flavor // see SetFlavor
+	skipUnsupported bool
+	rowAllocator func(columnsCount int) []driver.Value
+	logger Logger // the package's if nil, see NewParserWithLogger
 }
 
 func newEventParser() (parser *eventParser) {
 	parser = new(eventParser)
 	parser.tableMap = make(map[uint64]*TableMapEvent)
+	parser.tableMapCacheSize = DEFAULT_TABLE_MAP_CACHE_SIZE
+	parser.tables = make(map[string][]Column)
+	parser.location = time.UTC
+	parser.validateChecksum = true
+	parser.checksumAlgorithm = BINLOG_CHECKSUM_ALG_UNDEF
 	return
 }
 
+// Returns the logger of the parser
+func (parser *eventParser) log() (Logger) {
+	if parser.logger != nil {
+		return parser.logger
+	}
+	return packageLogger()
+}
+
+// SetTextAsString makes the parser return the values of CHAR, VARCHAR,
+// BLOB/TEXT and JSON columns as string instead of []byte, except for columns
+// registered with the binary character set. Only use it if the column data is
+// known to be text. Text of columns whose charset is known, from the optional
+// metadata or RegisterTable, is transcoded to UTF-8, see
+// RegisterCharsetDecoder.
+func (parser *eventParser) SetTextAsString(textAsString bool) {
+	parser.textAsString = textAsString
+}
+
+// Returns the decoded value of a string or blob column i
+func (parser *eventParser) stringValue(tableMap *TableMapEvent, i int, value []byte) (driver.Value, error) {
+	if !parser.textAsString || tableMap.isBinary(i) {
+		return value, nil
+	}
+	if i < len(tableMap.decoders) && tableMap.decoders[i] != nil {
+		decoded, e := tableMap.decoders[i](value)
+		if e != nil {
+			return nil, fmt.Errorf("Can't decode the text of column %d: %v", i, e)
+		}
+		value = decoded
+	}
+	return string(value), nil
+}
+
+// SetLocation sets the time zone TIMESTAMP columns are returned in. MySQL
+// stores them in UTC, which is also the default.
+func (parser *eventParser) SetLocation(loc *time.Location) {
+	parser.location = loc
+}
+
+// RegisterTable tells the parser how the columns of schema.table are declared,
+// in table order. The definition is ignored if its column count doesn't match
+// the table map the server sends.
+func (parser *eventParser) RegisterTable(schema, table string, columns []Column) {
+	parser.tables[schema + "." + table] = columns
+}
+
+// PartitionKey returns a key identifying a row of schema.table by its primary
+// key, so that changes to the same row can be routed to the same partition of
+// a message queue and stay in order. Composite keys use all their columns. For
+// tables without a registered primary key the key is a hash of the whole row.
+func (parser *eventParser) PartitionKey(schema, table string, row []driver.Value) []byte {
+	var key bytes.Buffer
+	key.WriteString(schema + "." + table)
+
+	hasPrimaryKey := false
+	for i, column := range parser.tables[schema + "." + table] {
+		if column.PrimaryKey && i < len(row) {
+			writePartitionKeyValue(&key, row[i])
+			hasPrimaryKey = true
+		}
+	}
+	if hasPrimaryKey {
+		return key.Bytes()
+	}
+
+	for _, value := range row {
+		writePartitionKeyValue(&key, value)
+	}
+	hash := fnv.New64a()
+	hash.Write(key.Bytes())
+	return hash.Sum(nil)
+}
+
+// Appends a length-prefixed encoding of a decoded column value
+func writePartitionKeyValue(key *bytes.Buffer, value driver.Value) {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		key.WriteByte(0xff)
+		return
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case time.Time:
+		data = []byte(v.UTC().Format(time.RFC3339Nano))
+	default:
+		data = []byte(fmt.Sprint(v))
+	}
+	key.Write(lengthCodedBinaryToBytes(uint64(len(data))))
+	key.Write(data)
+}
+
+// Table ids are only valid within a binlog file, and may be reused for another
+// table in the next one, so the table maps are forgotten when a new file starts.
+func (parser *eventParser) resetTableMaps() {
+	parser.tableMapLock.Lock()
+	defer parser.tableMapLock.Unlock()
+	parser.tableMap = make(map[uint64]*TableMapEvent)
+	parser.oldTableMap = nil
+}
+
+const DEFAULT_TABLE_MAP_CACHE_SIZE = 10000
+
+// SetTableMapCacheSize bounds the memory used for table maps, which servers
+// creating many temporary tables would otherwise grow within a binlog file.
+// Table maps are kept in two generations of up to size entries: when the
+// current one is full it replaces the previous one, and table maps used from
+// the previous generation move back to the current one. So a table map is
+// kept at least until size other table maps were read after its last use,
+// and the rows events of a statement, which follow its table maps, always find
+// them as long as a statement uses fewer than size tables. 0 disables the
+// bound.
+func (parser *eventParser) SetTableMapCacheSize(size int) {
+	parser.tableMapCacheSize = size
+}
+
+func (parser *eventParser) addTableMap(tableMap *TableMapEvent) {
+	parser.tableMapLock.Lock()
+	defer parser.tableMapLock.Unlock()
+	parser.storeTableMap(tableMap)
+}
+
+// Adds tableMap to the current generation, with tableMapLock held
+func (parser *eventParser) storeTableMap(tableMap *TableMapEvent) {
+	parser.tableMap[tableMap.tableId] = tableMap
+	if parser.tableMapCacheSize > 0 && len(parser.tableMap) >= parser.tableMapCacheSize {
+		parser.oldTableMap = parser.tableMap
+		parser.tableMap = make(map[uint64]*TableMapEvent)
+	}
+}
+
+func (parser *eventParser) lookupTableMap(tableId uint64) (*TableMapEvent) {
+	parser.tableMapLock.Lock()
+	defer parser.tableMapLock.Unlock()
+	if tableMap, ok := parser.tableMap[tableId]; ok {
+		return tableMap
+	}
+	tableMap, ok := parser.oldTableMap[tableId]
+	if !ok {
+		return nil
+	}
+	delete(parser.oldTableMap, tableId)
+	parser.storeTableMap(tableMap)
+	return tableMap
+}
+
+// TableMaps returns the table maps the rows events that follow can refer to,
+// by table id: those read since the last format description or rotate event,
+// within the bound of SetTableMapCacheSize. The map is a copy, so it can be
+// used while the parser goes on, e.g. from another goroutine.
+func (parser *eventParser) TableMaps() (map[uint64]*TableMapEvent) {
+	parser.tableMapLock.Lock()
+	defer parser.tableMapLock.Unlock()
+	tableMaps := make(map[uint64]*TableMapEvent, len(parser.tableMap) + len(parser.oldTableMap))
+	for tableId, tableMap := range parser.oldTableMap {
+		tableMaps[tableId] = tableMap
+	}
+	for tableId, tableMap := range parser.tableMap {
+		tableMaps[tableId] = tableMap
+	}
+	return tableMaps
+}
+
+// ChecksumAlgorithm returns the checksum algorithm of the current binlog file,
+// from its format description event. Before one was parsed it returns the
+// algorithm set with SetChecksumAlgorithm, BINLOG_CHECKSUM_ALG_UNDEF by
+// default.
+func (parser *eventParser) ChecksumAlgorithm() (uint8) {
+	if parser.format == nil {
+		return parser.checksumAlgorithm
+	}
+	return parser.format.checksumAlgorithm
+}
+
+// SetChecksumAlgorithm sets the checksum algorithm of the events preceding the
+// first format description event, e.g. the ROTATE_EVENT a master starts a dump
+// with, which carries the checksum negotiated for the dump. The format
+// description event takes precedence once parsed.
+func (parser *eventParser) SetChecksumAlgorithm(algorithm uint8) {
+	parser.checksumAlgorithm = algorithm
+}
+
+// Flavor returns the flavor of the server that logged the current binlog file,
+// told from the server version in its format description event. Before one
+// was parsed it returns the flavor set with SetFlavor, FLAVOR_MYSQL by default.
+func (parser *eventParser) Flavor() (Flavor) {
+	if parser.format == nil {
+		return parser.flavor
+	}
+	return parser.format.Flavor()
+}
+
+// SetFlavor sets the flavor of the events preceding the first format
+// description event, which takes precedence once parsed. With FLAVOR_MARIADB
+// the MariaDB specific event types are parsed, e.g. into a MariaDBGTIDEvent,
+// instead of a GenericEvent.
+func (parser *eventParser) SetFlavor(flavor Flavor) {
+	parser.flavor = flavor
+}
+
+// EnableChecksumValidation sets whether the CRC32 of events is checked when the
+// server logs checksums, which is the default. A mismatch is returned as an
+// ErrChecksumMismatch. Without validation the checksums are only stripped.
+func (parser *eventParser) EnableChecksumValidation(validate bool) {
+	parser.validateChecksum = validate
+}
+
+// SetTableFilter makes the parser skip the rows of rows events on tables for
+// which filter returns false, which saves decoding them. The events are still
+// returned, without rows, and RowsEvent.Filtered tells them apart. filter is
+// called once for each TABLE_MAP_EVENT, nil disables filtering.
+func (parser *eventParser) SetTableFilter(filter func(schema, table string) bool) {
+	parser.tableFilter = filter
+}
+
+// UnsupportedValue stands for the value of a column the parser can't decode,
+// in rows parsed with SetSkipUnsupported.
+type UnsupportedValue struct {
+	Type FieldType
+	Data []byte // the undecoded value
+}
+
+// SetSkipUnsupported makes the parser put an UnsupportedValue in place of the
+// values of columns whose type it can't decode, instead of failing the rows
+// event with an ErrUnsupportedFieldType. This only works if the size of the
+// value can be told from the column type and meta, otherwise such columns
+// still fail the event.
+func (parser *eventParser) SetSkipUnsupported(skip bool) {
+	parser.skipUnsupported = skip
+}
+
+// SetRowAllocator makes the parser get the rows of rows events from alloc
+// instead of allocating each, which adds up at high row rates. alloc returns a
+// row of at least columnsCount values, e.g. one of the rows the caller is done
+// with, which the parser clears, see also NewRowSlabAllocator. nil restores
+// the default.
+func (parser *eventParser) SetRowAllocator(alloc func(columnsCount int) []driver.Value) {
+	parser.rowAllocator = alloc
+}
+
+// SetRawMode makes the parser skip value decoding of rows events: each row is
+// only split into its columns' raw bytes, available from RowsEvent.RawColumns.
+func (parser *eventParser) SetRawMode(raw bool) {
+	parser.rawMode = raw
+}
+
+// ErrUnsupportedFieldType is returned when a rows event has a column of a type
+// the parser can't decode. Unlike other errors it doesn't mean the event is
+// corrupt.
+type ErrUnsupportedFieldType struct {
+	Type FieldType
+	Column int
+}
+
+func (e *ErrUnsupportedFieldType) Error() string {
+	return fmt.Sprintf("Unsupported field type %s in column %d", fieldTypeName(e.Type), e.Column)
+}
+
+// ErrChecksumMismatch is returned when the CRC32 of an event doesn't match the
+// checksum the server wrote after it.
+type ErrChecksumMismatch struct {
+	Header EventHeader
+	Checksum uint32
+	Computed uint32
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("Binlog checksum mismatch in %s at log position %d: event has %#08x, computed %#08x",
+	                   e.Header.EventName(), e.Header.LogPos, e.Checksum, e.Computed)
+}
+
+// Removes the trailing CRC32 of an event, verifying it if validate is set
+func stripChecksum(data []byte, validate bool) ([]byte, error) {
+	n := len(data) - BINLOG_CHECKSUM_LEN
+	if n < 19 {
+		return nil, io.EOF
+	}
+	if !validate {
+		return data[:n], nil
+	}
+	checksum := binary.LittleEndian.Uint32(data[n:])
+	if computed := crc32.ChecksumIEEE(data[:n]); computed != checksum {
+		e := &ErrChecksumMismatch{Checksum: checksum, Computed: computed}
+		binary.Read(bytes.NewReader(data), binary.LittleEndian, &e.Header)
+		return nil, e
+	}
+	return data[:n], nil
+}
+
+const (
+	INCIDENT_NONE uint16 = iota
+	INCIDENT_LOST_EVENTS
+)
+
+// ErrReplicationIncident is returned by the dump loop when the master logs an
+// INCIDENT_EVENT. After a LOST_EVENTS incident the binlog no longer holds every
+// change made on the master, so the consumer has to re-snapshot its data.
+type ErrReplicationIncident struct {
+	Header EventHeader
+	Incident uint16
+	Message string
+}
+
+func (e *ErrReplicationIncident) Error() string {
+	name := fmt.Sprintf("%d", e.Incident)
+	if e.Incident == INCIDENT_LOST_EVENTS {
+		name = "LOST_EVENTS"
+	}
+	return fmt.Sprintf("Replication incident %s at log position %d: %s", name, e.Header.LogPos, e.Message)
+}
+
+func newReplicationIncident(event *IncidentEvent) (*ErrReplicationIncident) {
+	return &ErrReplicationIncident{Header: event.header, Incident: event.incident, Message: event.message}
+}
+
+// DumpBinlog requests the binlog from filename at position and returns the
+// changes of its rows events, one row per changed table row:
+//	log_pos    int64      position following the rows event in the binlog
+//	timestamp  time.Time  when the statement started on the master
+//	schema     string
+//	table      string
+//	action     string     "INSERT", "UPDATE" or "DELETE"
+//	before     []byte     the row before the change as a JSON array, nil for inserts
+//	after      []byte     the row after the change as a JSON array, nil for deletes
+// Next blocks until the master logs the next change. It returns io.EOF if the
+// master ends the dump, or the error the dump failed with, including errors
+// starting it. Closing the rows stops the dump. See ChangeStream for row
+// changes with their values decoded.
 func (mc *mysqlConn) DumpBinlog(filename string, position uint32) (driver.Rows, error) {
-	parser := newEventParser()
-	ServerId := uint32(1) // Must be non-zero to avoid getting EOF packet
+	return mc.DumpBinlogContext(context.Background(), filename, position)
+}
+
+// DumpBinlogContext is like DumpBinlog, but Next returns ctx.Err() as soon as
+// ctx is done. See DumpBinlogTo for the state of the connection afterwards.
+func (mc *mysqlConn) DumpBinlogContext(ctx context.Context, filename string, position uint32) (driver.Rows, error) {
+	if e := ctx.Err(); e != nil {
+		return nil, e
+	}
+	return mc.newBinlogRows(ctx, filename, position), nil
+}
+
+// DumpBinlogTo requests the binlog from filename at position and sends every
+// parsed event to out, until the master sends EOF, an error occurs or ctx is
+// done. serverId must be non-zero and unique among the master's replicas.
+// Canceling ctx interrupts the blocked read, which leaves the connection
+// unusable, so it has to be closed afterwards. StopDump does both. MariaDB
+// masters are asked to send their GTID events, see MariaDBGTIDEvent. The
+// events are parsed with the options of the parser set with SetParser.
+func (mc *mysqlConn) DumpBinlogTo(ctx context.Context, serverId uint32, filename string, position uint32, out chan<- BinlogEvent) error {
+	if e := ctx.Err(); e != nil {
+		return e
+	}
+	checksum, e := mc.negotiateChecksum()
+	if e != nil {
+		return e
+	}
+	if mc.flavor() == FLAVOR_MARIADB {
+		if e = mc.exec(fmt.Sprintf("SET @mariadb_slave_capability = %d", MARIA_SLAVE_CAPABILITY_GTID)); e != nil {
+			return e
+		}
+	}
 	flags := uint16(0)
 
-	e := mc.writeCommandPacket(COM_BINLOG_DUMP, position, flags, ServerId, filename)
+	mc.log().Debugf("Dumping binlog from %s:%d as server id %d", filename, position, serverId)
+	e = mc.writeCommandPacket(COM_BINLOG_DUMP, position, flags, serverId, filename)
 	if e != nil {
-		return nil, e
+		return e
+	}
+	return duplicateReplicaError(serverId, mc.readBinlogEvents(ctx, checksum, out))
+}
+
+// SetParser makes the following binlog dumps parse their events with parser,
+// so that its options apply to them, e.g. SetTableFilter, RegisterTable or
+// SetTextAsString. A dump sets the checksum algorithm and flavor of the parser
+// to the ones of the master and forgets its format description and table maps.
+// The parser must not be used elsewhere during a dump. nil restores a parser
+// with the default options.
+func (mc *mysqlConn) SetParser(parser *Parser) {
+	mc.parser = parser
+}
+
+// Returns the flavor of the server, told from the version of its handshake
+func (mc *mysqlConn) flavor() (Flavor) {
+	return flavorOfVersion(mc.server.version)
+}
+
+// Error number of a query on a system variable the server doesn't know
+const ER_UNKNOWN_SYSTEM_VARIABLE uint16 = 1193
+
+// Tells the master the replica handles the checksum algorithm the master logs
+// with, as replicas do before a dump, and returns it. Masters since 5.6 refuse
+// to send checksummed events to replicas that don't, and checksum the events
+// of the dump preceding the first format description event, e.g. its initial
+// ROTATE_EVENT, with it. Older masters know no checksums.
+func (mc *mysqlConn) negotiateChecksum() (uint8, error) {
+	value, e := mc.getSystemVar("global.binlog_checksum")
+	if mysqlErr, ok := e.(*MySQLError); ok && mysqlErr.Number == ER_UNKNOWN_SYSTEM_VARIABLE {
+		return BINLOG_CHECKSUM_ALG_OFF, nil
+	}
+	if e != nil {
+		return 0, e
+	}
+
+	var algorithm uint8
+	switch value = strings.ToUpper(value); value {
+	case "NONE":
+		algorithm = BINLOG_CHECKSUM_ALG_OFF
+	case "CRC32":
+		algorithm = BINLOG_CHECKSUM_ALG_CRC32
+	default:
+		return 0, fmt.Errorf("Unknown binlog checksum algorithm %q", value)
+	}
+	// Set the value read instead of @@global.binlog_checksum, which might have
+	// changed since
+	if e = mc.exec("SET @master_binlog_checksum = '" + value + "'"); e != nil {
+		return 0, e
+	}
+	return algorithm, nil
+}
+
+const (
+	SEMI_SYNC_INDICATOR byte = 0xef
+	SEMI_SYNC_ACK_REQ byte = 0x01
+)
+
+// EnableSemiSync makes the connection a semi-synchronous replica for the
+// following binlog dumps. The master then prefixes every event with a semi-sync
+// header and waits for the replica to acknowledge the events that end a
+// transaction, which is done once they were received from the dump channel.
+// It requires the rpl_semi_sync_master plugin on the master.
+func (mc *mysqlConn) EnableSemiSync() error {
+	if e := mc.exec("SET @rpl_semi_sync_slave = 1"); e != nil {
+		return e
+	}
+	mc.semiSync = true
+	return nil
+}
+
+// Tells a semi-sync master the event ending at position in filename was
+// received. The acknowledgement isn't part of the dump's packet sequence.
+func (mc *mysqlConn) writeSemiSyncAck(filename string, position uint64) error {
+	sequence := mc.sequence
+	mc.sequence = 0
+
+	pktLen := 1 + 8 + len(filename)
+	data := make([]byte, 0, pktLen+4)
+	data = append(data, uint24ToBytes(uint32(pktLen))...)
+	data = append(data, mc.sequence)
+	data = append(data, SEMI_SYNC_INDICATOR)
+	data = append(data, uint64ToBytes(position)...)
+	data = append(data, []byte(filename)...)
+	e := mc.writePacket(&data)
+
+	mc.sequence = sequence
+	return e
+}
+
+// ErrHeartbeatTimeout is returned by the dump loop when the master sent
+// neither an event nor a heartbeat for Timeout, see SetHeartbeat.
+type ErrHeartbeatTimeout struct {
+	Timeout time.Duration
+}
+
+func (e *ErrHeartbeatTimeout) Error() string {
+	return fmt.Sprintf("No binlog event or heartbeat from the master for %v", e.Timeout)
+}
+
+// SetHeartbeat makes the master send a HEARTBEAT_EVENT every period the binlog
+// has no new events during the following binlog dumps, and the dump fail with
+// an ErrHeartbeatTimeout if nothing arrives for timeout, so a dead connection
+// is noticed. A zero timeout stands for 3 periods, a zero period disables
+// both.
+func (mc *mysqlConn) SetHeartbeat(period, timeout time.Duration) error {
+	// The master reads the period in nanoseconds
+	if e := mc.exec(fmt.Sprintf("SET @master_heartbeat_period = %d", period.Nanoseconds())); e != nil {
+		return e
+	}
+	switch {
+	case period == 0:
+		mc.binlogTimeout = 0
+	case timeout == 0:
+		mc.binlogTimeout = 3 * period
+	default:
+		mc.binlogTimeout = timeout
+	}
+	return nil
+}
+
+// SetReplicaUUID sets the UUID the connection identifies itself with to the
+// master during the following binlog dumps, as replicas do with their
+// @@server_uuid. Since MySQL 5.6 a master starting a dump ends the dump of any
+// other replica with the same UUID or, without one, the same server id, so
+// consumers running side by side each need their own. Using a stable UUID per
+// consumer also lets its reconnects replace its own stale dump.
+func (mc *mysqlConn) SetReplicaUUID(uuid string) error {
+	sid, e := parseUUID(uuid)
+	if e != nil {
+		return e
+	}
+	return mc.exec("SET @slave_uuid = '" + formatUUID(sid) + "'")
+}
+
+// ServerIdFromUUID derives a server id from a replica UUID, for consumers that
+// set one with SetReplicaUUID and need a server id that is stable and unlikely
+// to collide with other consumers'. It is never 0.
+func ServerIdFromUUID(uuid string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.ToLower(uuid)))
+	if id := hash.Sum32(); id != 0 {
+		return id
 	}
+	return 1
+}
+
+// ErrDuplicateReplica is returned by the dump loop when the master ended the
+// dump because another replica with the same UUID or server id started one,
+// see SetReplicaUUID.
+type ErrDuplicateReplica struct {
+	ServerId uint32
+	Err *MySQLError
+}
+
+func (e *ErrDuplicateReplica) Error() string {
+	return fmt.Sprintf("Another replica with server id %d or the same UUID connected to the master: %v", e.ServerId, e.Err)
+}
+
+// Error number of binlog errors the master ends a dump with
+const ER_MASTER_FATAL_ERROR_READING_BINLOG uint16 = 1236
+
+// Turns the error a dump by serverId ended with into an ErrDuplicateReplica
+// if the master ended it for another replica
+func duplicateReplicaError(serverId uint32, e error) error {
+	mysqlErr, ok := e.(*MySQLError)
+	if ok && mysqlErr.Number == ER_MASTER_FATAL_ERROR_READING_BINLOG && strings.Contains(mysqlErr.Message, "same server_uuid/server_id") {
+		return &ErrDuplicateReplica{ServerId: serverId, Err: mysqlErr}
+	}
+	return e
+}
+
+// DumpMetrics receives statistics from the binlog dumps of a connection, see
+// SetMetrics, e.g. to update Prometheus counters and gauges. The methods are
+// called from the dump loop, so they must not block.
+type DumpMetrics interface {
+	// Called with the size of every packet received, headers excluded
+	BytesReceived(n int)
+	// Called for every event parsed
+	EventParsed(eventType EventType)
+	// Called when an event fails to parse, which ends the dump with err
+	ParseFailed(eventType EventType, err error)
+	// Called with the time elapsed since the master logged each event, and
+	// with 0 on heartbeats, which the master only sends once the replica
+	// has all events. Artificial events and format descriptions don't count.
+	ReplicationLag(lag time.Duration)
+	// Called for every HEARTBEAT_EVENT, see SetHeartbeat
+	HeartbeatReceived()
+}
+
+// SetMetrics makes the following binlog dumps report to metrics, nil stops
+// reporting.
+func (mc *mysqlConn) SetMetrics(metrics DumpMetrics) {
+	mc.metrics = metrics
+}
+
+// Reports the metrics of a parsed event, see DumpMetrics
+func (mc *mysqlConn) reportEvent(event BinlogEvent) {
+	header := event.Header()
+	mc.metrics.EventParsed(header.EventType)
+	switch {
+	case header.EventType == HEARTBEAT_EVENT:
+		mc.metrics.HeartbeatReceived()
+		mc.metrics.ReplicationLag(0)
+	case header.EventType == FORMAT_DESCRIPTION_EVENT, header.Flags & LOG_EVENT_ARTIFICIAL_F != 0, header.Timestamp == 0:
+	default:
+		mc.metrics.ReplicationLag(time.Since(time.Unix(int64(header.Timestamp), 0)))
+	}
+}
+
+// Flag of COM_BINLOG_DUMP_GTID telling the master a GTID set follows
+const BINLOG_THROUGH_GTID uint16 = 0x04
+
+// DumpBinlogGTID is like DumpBinlogTo, but starts with the first transaction
+// missing from gtidSet, e.g. the replica's @@gtid_executed, instead of at a
+// file position. This requires GTID mode on the master, and MySQL: MariaDB
+// GTIDs are different, see MariaDBGTID.
+func (mc *mysqlConn) DumpBinlogGTID(ctx context.Context, serverId uint32, gtidSet string, out chan<- BinlogEvent) error {
+	if e := ctx.Err(); e != nil {
+		return e
+	}
+	set, e := ParseGTIDSet(gtidSet)
+	if e != nil {
+		return e
+	}
+	data, e := set.encode()
+	if e != nil {
+		return e
+	}
+
+	checksum, e := mc.negotiateChecksum()
+	if e != nil {
+		return e
+	}
+
+	mc.log().Debugf("Dumping binlog after GTID set %s as server id %d", set, serverId)
+	e = mc.writeCommandPacket(COM_BINLOG_DUMP_GTID, BINLOG_THROUGH_GTID, serverId, "", uint64(4), data)
+	if e != nil {
+		return e
+	}
+	return duplicateReplicaError(serverId, mc.readBinlogEvents(ctx, checksum, out))
+}
 
+// TableMaps returns the table maps of the binlog dump running on the
+// connection, see Parser.TableMaps, or nil if none is running
+func (mc *mysqlConn) TableMaps() (map[uint64]*TableMapEvent) {
+	mc.dumpLock.Lock()
+	parser := mc.dumpParser
+	mc.dumpLock.Unlock()
+	if parser == nil {
+		return nil
+	}
+	return parser.TableMaps()
+}
+
+// StopDump stops the binlog dump running on the connection, if any, waits for
+// it to return and closes the connection. Closing it ends the master's dump
+// thread, which releases the replica's server id, and discards the session
+// state set up for the dump by SetHeartbeat and EnableSemiSync. To restart the
+// dump, open a new connection.
+func (mc *mysqlConn) StopDump() error {
+	mc.dumpLock.Lock()
+	cancel, done := mc.dumpCancel, mc.dumpDone
+	mc.dumpLock.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	return mc.Close()
+}
+
+// Reads the events the master sends after a binlog dump command and sends them
+// to out, see DumpBinlogTo. checksum is the algorithm negotiated for the dump.
+func (mc *mysqlConn) readBinlogEvents(ctx context.Context, checksum uint8, out chan<- BinlogEvent) error {
+	parser := newEventParser()
+	parser.logger = mc.logger
+	if mc.parser != nil {
+		parser = mc.parser.eventParser
+		// The master starts the dump over with a rotation and a format
+		// description
+		parser.format = nil
+		parser.resetTableMaps()
+	}
+	parser.SetChecksumAlgorithm(checksum)
+	parser.SetFlavor(mc.flavor())
+
+	// Let StopDump cancel the dump
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	mc.dumpLock.Lock()
+	mc.dumpCancel, mc.dumpDone, mc.dumpParser = cancel, done, parser
+	mc.dumpLock.Unlock()
+	defer func() {
+		mc.dumpLock.Lock()
+		mc.dumpCancel, mc.dumpDone, mc.dumpParser = nil, nil, nil
+		mc.dumpLock.Unlock()
+	}()
+
+	// Both cancelation and the heartbeat timeout work with read deadlines. The
+	// lock keeps the timeout from overriding the deadline of a cancelation.
+	var deadlineLock sync.Mutex
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			deadlineLock.Lock()
+			select {
+			case <-stop: // canceled on return
+			default:
+				mc.netConn.SetReadDeadline(time.Unix(1, 0))
+			}
+			deadlineLock.Unlock()
+		case <-stop:
+		}
+	}()
+	if mc.binlogTimeout > 0 {
+		defer func() {
+			if ctx.Err() == nil {
+				mc.netConn.SetReadDeadline(time.Time{})
+			}
+		}()
+	}
+
+	var filename string
 	for {
+		if mc.binlogTimeout > 0 {
+			deadlineLock.Lock()
+			if ctx.Err() == nil {
+				mc.netConn.SetReadDeadline(time.Now().Add(mc.binlogTimeout))
+			}
+			deadlineLock.Unlock()
+		}
+		readStart := time.Now()
 		pkt, e := mc.readPacket()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		switch {
+		case e != nil && mc.binlogTimeout > 0 && time.Since(readStart) >= mc.binlogTimeout:
+			// readPacket doesn't pass on the timeout error
+			return &ErrHeartbeatTimeout{Timeout: mc.binlogTimeout}
+		case e != nil:
+			return e
+		case len(pkt) == 0:
+			return errors.New("Empty packet in binlog stream")
+		case pkt[0] == 254: // EOF packet
+			return nil
+		case pkt[0] == 255:
+			return mc.handleErrorPacket(pkt)
+		case pkt[0] != 0:
+			return fmt.Errorf("Unexpected packet in binlog stream:\n%s", hex.Dump(pkt))
+		}
+
+		if mc.metrics != nil {
+			mc.metrics.BytesReceived(len(pkt))
+		}
+		data := pkt[1:]
+		ackRequested := false
+		if mc.semiSync {
+			if len(data) < 2 || data[0] != SEMI_SYNC_INDICATOR {
+				return fmt.Errorf("Missing semi-sync header in binlog stream:\n%s", hex.Dump(pkt))
+			}
+			ackRequested = data[1] & SEMI_SYNC_ACK_REQ != 0
+			data = data[2:]
+		}
+
+		event, e := parser.parseEvent(data)
+		switch {
+		case mc.metrics == nil:
+		case e == nil:
+			mc.reportEvent(event)
+		case len(data) > 4:
+			mc.metrics.ParseFailed(EventType(data[4]), e)
+		}
 		if e != nil {
-			return nil, e
-		} else if pkt[0] == 254 { // EOF packet
-			break
+			return e
 		}
-		if pkt[0] == 0 {
-			event, e := parser.parseEvent(pkt[1:])
-			if e != nil {
-				return nil, e
+		if rotate, ok := event.(*RotateEvent); ok {
+			filename = rotate.filename
+		}
+		select {
+		case out <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if ackRequested {
+			if e = mc.writeSemiSyncAck(filename, uint64(event.Header().LogPos)); e != nil {
+				return e
 			}
-			event.Print()
-		} else {
-			fmt.Printf("Unknown packet:\n%s\n\n", hex.Dump(pkt))
 		}
-		fmt.Println()
+		if incident, ok := event.(*IncidentEvent); ok {
+			return newReplicationIncident(incident)
+		}
 	}
-
-	return nil, nil
 }
 
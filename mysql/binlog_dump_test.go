@@ -0,0 +1,538 @@
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The master end of a connection, which serves a binlog dump as a server
+// without binlog checksums does, unless checksum is set
+type testMaster struct {
+	conn net.Conn
+	sequence byte
+	checksum string // @@global.binlog_checksum, unknown to the server if empty
+}
+
+// Returns a connection to a testMaster
+func newTestConn() (*mysqlConn, *testMaster) {
+	client, server := net.Pipe()
+	mc := &mysqlConn{netConn: client, bufReader: bufio.NewReader(client), server: &serverSettings{version: "5.5.62-log"}}
+	return mc, &testMaster{conn: server}
+}
+
+// Like newTestConn over loopback TCP, whose buffers let both ends write at
+// once, e.g. COM_QUIT while the master sends events
+func newTestTCPConn() (*mysqlConn, *testMaster, error) {
+	listener, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		return nil, nil, e
+	}
+	defer listener.Close()
+	client, e := net.Dial("tcp", listener.Addr().String())
+	if e != nil {
+		return nil, nil, e
+	}
+	server, e := listener.Accept()
+	if e != nil {
+		client.Close()
+		return nil, nil, e
+	}
+	mc := &mysqlConn{netConn: client, bufReader: bufio.NewReader(client), server: &serverSettings{version: "5.5.62-log"}}
+	return mc, &testMaster{conn: server}, nil
+}
+
+// Reads a command packet, whose sequence the replies follow
+func (master *testMaster) readCommand() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, e := io.ReadFull(master.conn, header); e != nil {
+		return nil, e
+	}
+	master.sequence = header[3] + 1
+	data := make([]byte, int(header[0]) | int(header[1]) << 8 | int(header[2]) << 16)
+	_, e := io.ReadFull(master.conn, data)
+	return data, e
+}
+
+// Writes data as one packet, split if it takes MAX_PACKET_SIZE bytes or more
+func (master *testMaster) writePacket(data []byte) error {
+	for {
+		size := len(data)
+		if size > MAX_PACKET_SIZE {
+			size = MAX_PACKET_SIZE
+		}
+		header := []byte{byte(size), byte(size >> 8), byte(size >> 16), master.sequence}
+		master.sequence++
+		if _, e := master.conn.Write(append(header, data[:size]...)); e != nil {
+			return e
+		}
+		data = data[size:]
+		if size < MAX_PACKET_SIZE {
+			return nil
+		}
+	}
+}
+
+// Answers the checksum negotiation as a server without checksums and the dump
+// command with the events, then EOF. Returns the dump command.
+func (master *testMaster) serveDump(events ...[]byte) ([]byte, error) {
+	return master.serveDumpOf(func(command []byte) [][]byte {
+		return events
+	})
+}
+
+// Answers the checksum negotiation with master.checksum. Returns the query
+// setting @master_binlog_checksum, nil if the variable is unknown.
+func (master *testMaster) serveChecksum() ([]byte, error) {
+	if _, e := master.readCommand(); e != nil {
+		return nil, e
+	}
+	if master.checksum == "" {
+		return nil, master.writePacket(append([]byte{0xff, 0xa9, 0x04, '#'}, "HY000Unknown system variable 'binlog_checksum'"...))
+	}
+	// Catalog, schema, table, original table, name and original name, then
+	// the fixed fields, of a VAR_STRING column
+	name := "@@global.binlog_checksum"
+	column := append([]byte{3, 'd', 'e', 'f', 0, 0, 0, byte(len(name))}, name...)
+	column = append(column, 0, 0x0c, 0x21, 0, 0, 0, 0, 0, byte(FIELD_TYPE_VAR_STRING), 0, 0, 0x1f, 0, 0)
+	value := append([]byte{byte(len(master.checksum))}, master.checksum...)
+	for _, packet := range [][]byte{{1}, column, {0xfe, 0, 0, 0, 0}, value, {0xfe, 0, 0, 0, 0}} {
+		if e := master.writePacket(packet); e != nil {
+			return nil, e
+		}
+	}
+	command, e := master.readCommand()
+	if e != nil {
+		return nil, e
+	}
+	return command[1:], master.writePacket([]byte{0, 0, 0, 2, 0, 0, 0})
+}
+
+// Like serveDump, with the events binlog returns for the dump command
+func (master *testMaster) serveDumpOf(binlog func(command []byte) [][]byte) ([]byte, error) {
+	if _, e := master.serveChecksum(); e != nil {
+		return nil, e
+	}
+	command, e := master.readCommand()
+	if e != nil {
+		return nil, e
+	}
+	for _, event := range binlog(command) {
+		if e = master.writePacket(append([]byte{0}, event...)); e != nil {
+			return nil, e
+		}
+	}
+	return command, master.writePacket([]byte{0xfe, 0, 0, 0, 0})
+}
+
+// Runs a dump from a testMaster serving the events and returns the events
+// parsed
+func dumpTestEvents(parser *Parser, events ...[]byte) ([]BinlogEvent, error) {
+	mc, master := newTestConn()
+	defer mc.netConn.Close()
+	mc.SetParser(parser)
+	go master.serveDump(events...)
+
+	out := make(chan BinlogEvent)
+	done := make(chan error, 1)
+	go func() {
+		done <- mc.DumpBinlogTo(context.Background(), 1, "binlog.000001", 4, out)
+		close(out)
+	}()
+	var parsed []BinlogEvent
+	for event := range out {
+		parsed = append(parsed, event)
+	}
+	return parsed, <-done
+}
+
+func TestDumpBinlogParserTableFilter(t *testing.T) {
+	parser := NewParser()
+	parser.SetTableFilter(func(schema, table string) bool {
+		return table == "kept"
+	})
+	types, meta := []byte{byte(FIELD_TYPE_LONG)}, []byte{}
+	row := []byte{0, 7, 0, 0, 0}
+	events, err := dumpTestEvents(parser,
+		makeTableMap(1, "skipped", types, meta),
+		makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 1, row),
+		makeTableMap(2, "kept", types, meta),
+		makeRowsEvent(WRITE_ROWS_EVENTv1, 2, 1, row))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("%d events, want 4", len(events))
+	}
+	if rows := events[1].(*RowsEvent).Rows(); rows != nil || !events[1].(*RowsEvent).Filtered() {
+		t.Errorf("rows of the filtered table %v, filtered %v, want none", rows, events[1].(*RowsEvent).Filtered())
+	}
+	if rows := events[3].(*RowsEvent).Rows(); len(rows) != 1 || rows[0][0] != int64(7) || events[3].(*RowsEvent).Filtered() {
+		t.Errorf("rows %v, filtered %v, want [[7]]", rows, events[3].(*RowsEvent).Filtered())
+	}
+}
+
+func TestDumpBinlogParserRawMode(t *testing.T) {
+	decoded, err := dumpTestEvents(nil, testTableMap, testWriteRows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser := NewParser()
+	parser.SetRawMode(true)
+	raw, err := dumpTestEvents(parser, testTableMap, testWriteRows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := decoded[1].(*RowsEvent).Rows()[0]
+	want := []driver.Value{int64(1), []byte("x"), "b", uint64(16777215), []byte("yz"), time.Date(2022, time.June, 1, 12, 30, 0, 0, time.UTC)}
+	if !reflect.DeepEqual(row, want) {
+		t.Errorf("row %#v, want %#v", row, want)
+	}
+	if raw[1].(*RowsEvent).Rows() != nil {
+		t.Errorf("raw mode decoded the rows")
+	}
+	rawRow := raw[1].(*RowsEvent).RawColumns()[0]
+	// The values from the bytes they were decoded from
+	spans := [][]byte{testRow[1:5], testRow[5:7], testRow[7:8], testRow[8:11], testRow[11:15], testRow[15:20]}
+	if len(rawRow) != len(spans) {
+		t.Fatalf("%d raw columns, want %d", len(rawRow), len(spans))
+	}
+	for i, span := range spans {
+		if !bytes.Equal(rawRow[i], span) {
+			t.Errorf("raw column %d %x, want %x", i, rawRow[i], span)
+		}
+	}
+}
+
+func TestDumpBinlogParserRegisterTable(t *testing.T) {
+	// b BINARY(8)
+	tableMap := makeTableMap(1, "b", []byte{byte(FIELD_TYPE_STRING)}, []byte{byte(FIELD_TYPE_STRING), 8})
+	rows := makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 1, []byte{0, 3, 'a', 0, 'b'})
+
+	events, err := dumpTestEvents(nil, tableMap, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := events[1].(*RowsEvent).Rows()[0][0]; !bytes.Equal(value.([]byte), []byte("a\x00b")) {
+		t.Errorf("unregistered BINARY(8) %q, want \"a\\x00b\"", value)
+	}
+
+	parser := NewParser()
+	parser.RegisterTable("test", "b", []Column{{Name: "b", Charset: CHARSET_BINARY}})
+	events, err = dumpTestEvents(parser, tableMap, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := events[1].(*RowsEvent).Rows()[0][0]; !bytes.Equal(value.([]byte), []byte("a\x00b\x00\x00\x00\x00\x00")) {
+		t.Errorf("BINARY(8) %q, want it padded to 8 bytes", value)
+	}
+
+	tooLong := makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 1, []byte{0, 9, '1', '2', '3', '4', '5', '6', '7', '8', '9'})
+	if _, err = dumpTestEvents(parser, tableMap, tooLong); err == nil {
+		t.Error("BINARY(8) value of 9 bytes parsed")
+	}
+}
+
+func TestDumpBinlogParserLocation(t *testing.T) {
+	tableMap := makeTableMap(1, "ts", []byte{byte(FIELD_TYPE_TIMESTAMP)}, []byte{})
+	rows := makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 1,
+		[]byte{0, 0, 0, 0, 0},
+		[]byte{0, 1, 0, 0, 0},
+		[]byte{0, 0x6e, 0x68, 0x30, 0x62})
+
+	location := time.FixedZone("UTC+8", 8 * 60 * 60)
+	for _, loc := range []*time.Location{nil, location} {
+		parser := NewParser()
+		if loc != nil {
+			parser.SetLocation(loc)
+		} else {
+			loc = time.UTC
+		}
+		events, err := dumpTestEvents(parser, tableMap, rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := events[1].(*RowsEvent).Rows()
+		if value := got[0][0].(time.Time); !value.IsZero() {
+			t.Errorf("TIMESTAMP 0 decoded to %v, want the zero time", value)
+		}
+		want := []time.Time{time.Date(1970, time.January, 1, 0, 0, 1, 0, time.UTC), time.Date(2022, time.March, 15, 10, 20, 30, 0, time.UTC)}
+		for i, w := range want {
+			value := got[i + 1][0].(time.Time)
+			if !value.Equal(w) || value.Location() != loc {
+				t.Errorf("TIMESTAMP decoded to %v, want %v", value, w.In(loc))
+			}
+		}
+	}
+}
+
+func TestDumpBinlogParserTextAsString(t *testing.T) {
+	// name VARCHAR(10) CHARSET latin1, id VARBINARY(16)
+	tableMap := makeTableMap(1, "text", []byte{byte(FIELD_TYPE_VARCHAR), byte(FIELD_TYPE_VARCHAR)}, []byte{10, 0, 16, 0},
+		TABLE_MAP_DEFAULT_CHARSET, 3, 8, 1, CHARSET_BINARY)
+	id := []byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}
+	rows := makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 2, append([]byte{0, 4, 'c', 'a', 'f', 0xe9, 16}, id...))
+
+	events, err := dumpTestEvents(nil, tableMap, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := events[1].(*RowsEvent).Rows()[0]
+	if !reflect.DeepEqual(row, []driver.Value{[]byte("caf\xe9"), id}) {
+		t.Errorf("row %#v, want the bytes of both columns", row)
+	}
+
+	parser := NewParser()
+	parser.SetTextAsString(true)
+	events, err = dumpTestEvents(parser, tableMap, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row = events[1].(*RowsEvent).Rows()[0]
+	if !reflect.DeepEqual(row, []driver.Value{"café", id}) {
+		t.Errorf("row %#v, want the latin1 text as a UTF-8 string and the VARBINARY as bytes", row)
+	}
+}
+
+func TestDumpBinlogParserChecksumValidation(t *testing.T) {
+	fde := makeFormatDescription("8.0.32-log", BINLOG_CHECKSUM_ALG_CRC32)
+	rows := checksummed(makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 6, testRow))
+	rows[13] = 200 // LogPos
+	// A corrupt value
+	rows[len(rows) - BINLOG_CHECKSUM_LEN - 1] ^= 0x80
+
+	_, err := dumpTestEvents(nil, fde, checksummed(testTableMap), rows)
+	var mismatch *ErrChecksumMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("err %v, want ErrChecksumMismatch", err)
+	}
+	if mismatch.Header.EventType != WRITE_ROWS_EVENTv1 || mismatch.Header.LogPos != 200 {
+		t.Errorf("mismatch in %s at %d, want WRITE_ROWS_EVENTv1 at 200", mismatch.Header.EventType, mismatch.Header.LogPos)
+	}
+
+	parser := NewParser()
+	parser.EnableChecksumValidation(false)
+	events, err := dumpTestEvents(parser, fde, checksummed(testTableMap), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || len(events[2].(*RowsEvent).Rows()) != 1 {
+		t.Errorf("events %v, want the rows event parsed without validation", events)
+	}
+}
+
+func TestDumpBinlogParserTableMapCacheSize(t *testing.T) {
+	types, meta := []byte{byte(FIELD_TYPE_LONG)}, []byte{}
+	row := []byte{0, 7, 0, 0, 0}
+	events := [][]byte{
+		makeTableMap(1, "t1", types, meta),
+		makeTableMap(2, "t2", types, meta),
+		makeTableMap(3, "t3", types, meta),
+		makeTableMap(4, "t4", types, meta),
+		makeRowsEvent(WRITE_ROWS_EVENTv1, 3, 1, row),
+		makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 1, row),
+	}
+	if _, err := dumpTestEvents(nil, events...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tables 1 and 2 are forgotten once 3 and 4 fill the current generation
+	parser := NewParser()
+	parser.SetTableMapCacheSize(2)
+	parsed, err := dumpTestEvents(parser, events...)
+	if err == nil {
+		t.Fatal("rows event of an evicted table map parsed")
+	}
+	if len(parsed) != 5 || len(parsed[4].(*RowsEvent).Rows()) != 1 {
+		t.Errorf("events %v, want the rows event of table 3 parsed", parsed)
+	}
+}
+
+func TestDumpBinlogParserSkipUnsupported(t *testing.T) {
+	// The pre-5.6.4 TIME, 3 bytes
+	tableMap := makeTableMap(1, "time", []byte{byte(FIELD_TYPE_TIME), byte(FIELD_TYPE_LONG)}, []byte{})
+	rows := makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 2, []byte{0, 0x2e, 0x3c, 0x01, 7, 0, 0, 0})
+
+	_, err := dumpTestEvents(nil, tableMap, rows)
+	var unsupported *ErrUnsupportedFieldType
+	if !errors.As(err, &unsupported) || unsupported.Type != FIELD_TYPE_TIME || unsupported.Column != 0 {
+		t.Fatalf("err %v, want ErrUnsupportedFieldType of column 0", err)
+	}
+
+	parser := NewParser()
+	parser.SetSkipUnsupported(true)
+	events, err := dumpTestEvents(parser, tableMap, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := events[1].(*RowsEvent).Rows()[0]
+	want := []driver.Value{UnsupportedValue{Type: FIELD_TYPE_TIME, Data: []byte{0x2e, 0x3c, 0x01}}, int64(7)}
+	if !reflect.DeepEqual(row, want) {
+		t.Errorf("row %#v, want %#v", row, want)
+	}
+}
+
+func TestDumpBinlogLargeEvent(t *testing.T) {
+	tableMap := makeTableMap(1, "blob", []byte{byte(FIELD_TYPE_BLOB)}, []byte{4})
+	// A LONGBLOB value longer than a packet
+	value := bytes.Repeat([]byte("0123456789abcdef"), MAX_PACKET_SIZE / 16 + 1000)
+	row := binary.LittleEndian.AppendUint32([]byte{0}, uint32(len(value)))
+	rows := makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 1, append(row, value...))
+
+	events, err := dumpTestEvents(nil, tableMap, rows, testTableMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("%d events, want 3", len(events))
+	}
+	if got := events[1].(*RowsEvent).Rows()[0][0].([]byte); !bytes.Equal(got, value) {
+		t.Errorf("value of %d bytes, want the %d bytes split across packets", len(got), len(value))
+	}
+	if name := events[2].(*TableMapEvent).TableName(); name != "t" {
+		t.Errorf("table map of %s after the large event, want t", name)
+	}
+}
+
+func TestDumpBinlogIncident(t *testing.T) {
+	xid := makeEvent(XID_EVENT, 9, 0, 0, 0, 0, 0, 0, 0)
+	incident := atLogPos(makeEvent(INCIDENT_EVENT, append([]byte{byte(INCIDENT_LOST_EVENTS), 0, 11}, "lost events"...)...), 500)
+	events, err := dumpTestEvents(nil, xid, incident, xid)
+	var replicationIncident *ErrReplicationIncident
+	if !errors.As(err, &replicationIncident) {
+		t.Fatalf("err %v, want ErrReplicationIncident", err)
+	}
+	if replicationIncident.Incident != INCIDENT_LOST_EVENTS || replicationIncident.Message != "lost events" || replicationIncident.Header.LogPos != 500 {
+		t.Errorf("incident %+v, want LOST_EVENTS \"lost events\" at 500", replicationIncident)
+	}
+	// The dump ends after the incident, which is still delivered
+	if len(events) != 2 {
+		t.Fatalf("%d events, want 2", len(events))
+	}
+	if _, ok := events[1].(*IncidentEvent); !ok {
+		t.Errorf("last event %T, want *IncidentEvent", events[1])
+	}
+}
+
+func TestDumpBinlogHandlesXIDOnce(t *testing.T) {
+	xid := makeEvent(XID_EVENT, 9, 0, 0, 0, 0, 0, 0, 0)
+	events, err := dumpTestEvents(nil, makeQuery("BEGIN"), testTableMap, testWriteRows, xid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	for _, event := range events {
+		event.PrintTo(&output)
+	}
+	if n := strings.Count(output.String(), "EventType: XID_EVENT"); n != 1 {
+		t.Errorf("XID printed %d times, want once:\n%s", n, output.String())
+	}
+
+	// The rows of DumpBinlog hold each row change once
+	mc, master := newTestConn()
+	defer mc.netConn.Close()
+	go master.serveDump(makeQuery("BEGIN"), testTableMap, testWriteRows, xid)
+	rows, err := mc.DumpBinlog("binlog.000001", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	values := make([]driver.Value, len(rows.Columns()))
+	changes := 0
+	for {
+		if err = rows.Next(values); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		changes++
+	}
+	if changes != 1 {
+		t.Errorf("%d row changes, want 1", changes)
+	}
+}
+
+func TestNegotiateChecksum(t *testing.T) {
+	for _, c := range []struct {
+		checksum string
+		want uint8
+		set string
+	}{
+		{"", BINLOG_CHECKSUM_ALG_OFF, ""},
+		{"NONE", BINLOG_CHECKSUM_ALG_OFF, "SET @master_binlog_checksum = 'NONE'"},
+		{"CRC32", BINLOG_CHECKSUM_ALG_CRC32, "SET @master_binlog_checksum = 'CRC32'"},
+		{"crc32", BINLOG_CHECKSUM_ALG_CRC32, "SET @master_binlog_checksum = 'CRC32'"},
+	} {
+		mc, master := newTestConn()
+		master.checksum = c.checksum
+		set := make(chan []byte, 1)
+		go func() {
+			query, _ := master.serveChecksum()
+			set <- query
+		}()
+		algorithm, err := mc.negotiateChecksum()
+		mc.netConn.Close()
+		if err != nil {
+			t.Errorf("%q: %v", c.checksum, err)
+			continue
+		}
+		if algorithm != c.want {
+			t.Errorf("%q: algorithm %d, want %d", c.checksum, algorithm, c.want)
+		}
+		if query := <-set; string(query) != c.set {
+			t.Errorf("%q: query %q, want %q", c.checksum, query, c.set)
+		}
+	}
+
+	mc, master := newTestConn()
+	defer mc.netConn.Close()
+	master.checksum = "MD5"
+	go master.serveChecksum()
+	if _, err := mc.negotiateChecksum(); err == nil {
+		t.Errorf("unknown algorithm negotiated")
+	}
+}
+
+func TestDumpBinlogChecksumCRC32(t *testing.T) {
+	mc, master := newTestConn()
+	defer mc.netConn.Close()
+	master.checksum = "CRC32"
+	// The ROTATE_EVENT ahead of the format description event is checksummed
+	// with the negotiated algorithm
+	go master.serveDump(checksummed(makeRotate("binlog.000001", 4, true)),
+		makeFormatDescription("8.0.32-log", BINLOG_CHECKSUM_ALG_CRC32),
+		checksummed(testTableMap), checksummed(testWriteRows))
+
+	out := make(chan BinlogEvent)
+	done := make(chan error, 1)
+	go func() {
+		done <- mc.DumpBinlogTo(context.Background(), 1, "binlog.000001", 4, out)
+		close(out)
+	}()
+	var events []BinlogEvent
+	for event := range out {
+		events = append(events, event)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("%d events, want 4", len(events))
+	}
+	if rotate := events[0].(*RotateEvent); rotate.filename != "binlog.000001" {
+		t.Errorf("rotate to %q, want binlog.000001", rotate.filename)
+	}
+	if rows := events[3].(*RowsEvent).Rows(); len(rows) != 1 || rows[0][0] != int64(1) {
+		t.Errorf("rows %v, want the checksummed row", rows)
+	}
+}
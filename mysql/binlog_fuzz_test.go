@@ -0,0 +1,88 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Lays the events end to end
+func concat(events ...[]byte) (data []byte) {
+	for _, event := range events {
+		data = append(data, event...)
+	}
+	return
+}
+
+func FuzzParseEvent(f *testing.F) {
+	fde := makeFormatDescription("8.0.32-log", BINLOG_CHECKSUM_ALG_OFF)
+	query := makeQuery("BEGIN")
+	gtid := makeEvent(GTID_EVENT, append(append([]byte{1}, make([]byte, 16)...),
+		7, 0, 0, 0, 0, 0, 0, 0, 2, 6, 0, 0, 0, 0, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0)...)
+	xid := makeEvent(XID_EVENT, 9, 0, 0, 0, 0, 0, 0, 0)
+	previousGTIDs := makeEvent(PREVIOUS_GTIDS_EVENT, append(append([]byte{1, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 16)...),
+		1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0)...)
+	rotate := makeRotate("binlog.000002", 4, false)
+	inner := concat(testTableMap, testWriteRows)
+	payload := makeEvent(TRANSACTION_PAYLOAD_EVENT, append([]byte{1, 1, byte(len(inner)), 2, 3, 0xfc, TRANSACTION_COMPRESSION_NONE, 0, 3, 1, byte(len(inner)), 0}, inner...)...)
+	mariaDBGTID := makeEvent(MARIADB_GTID_EVENT, 100, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, MARIADB_FL_GROUP_COMMIT_ID, 5, 0, 0, 0, 0, 0, 0, 0)
+	mariaDBGTIDList := makeEvent(MARIADB_GTID_LIST_EVENT, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 100, 0, 0, 0, 0, 0, 0, 0)
+
+	// JSON columns, one document of nested arrays sharing their offsets
+	jsonTableMap := makeTableMap(2, "j", []byte{byte(FIELD_TYPE_JSON)}, []byte{4})
+	jsonRow := func(document []byte) []byte {
+		return append(binary.LittleEndian.AppendUint32([]byte{0}, uint32(len(document))), document...)
+	}
+	jsonRows := makeRowsEvent(WRITE_ROWS_EVENTv1, 2, 1,
+		jsonRow(makeTestJSON(testJSONObject{{"a", 1}, {"b", []interface{}{"x", testJSONObject{}}}})),
+		jsonRow(makeSharedOffsetsJSON(22)))
+	// A partial update replacing $.a with diffs
+	partialUpdate := makeEvent(PARTIAL_UPDATE_ROWS_EVENT, concat([]byte{2, 0, 0, 0, 0, 0, 0, 0, 2, 0, 1, 0x01, 0x01},
+		jsonRow(makeTestJSON(testJSONObject{{"a", 1}})),
+		[]byte{PARTIAL_JSON_UPDATES, 0x01}, jsonRow(makeTestJSONDiff(JSON_DIFF_REPLACE, "$.a", []interface{}{2})))...)
+
+	f.Add(concat(fde, jsonTableMap, jsonRows))
+	f.Add(concat(fde, jsonTableMap, partialUpdate))
+	f.Add(concat(fde, previousGTIDs, gtid, query, testTableMap, testWriteRows, testUpdateRows, xid, rotate))
+	f.Add(concat(fde, gtid, payload))
+	f.Add(concat(makeFormatDescription("8.0.32-log", BINLOG_CHECKSUM_ALG_CRC32), checksummed(query), checksummed(testTableMap), checksummed(testUpdateRows), checksummed(xid)))
+	f.Add(concat(makeFormatDescription("10.6.12-MariaDB-log", BINLOG_CHECKSUM_ALG_OFF), mariaDBGTIDList, mariaDBGTID, testTableMap, testWriteRows, xid))
+	for _, event := range [][]byte{fde, query, gtid, xid, previousGTIDs, rotate, testTableMap, testWriteRows, payload} {
+		f.Add(event)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Values can repeat what a table map holds once per row, e.g. SET
+		// members, so the output may grow with the square of the input, but
+		// not faster, e.g. exponentially with JSON nesting
+		limit := 1 << 20 + len(data) * len(data)
+		var written countingWriter
+
+		// The data is a sequence of events, so that rows events can follow
+		// their table map, each parsed whether or not the ones before it were
+		parser := newEventParser()
+		for len(data) >= eventHeaderSize {
+			size := int(binary.LittleEndian.Uint32(data[9:]))
+			if size < eventHeaderSize || size > len(data) {
+				size = len(data)
+			}
+			event, err := parser.parseEvent(data[:size])
+			if err == nil {
+				event.PrintTo(&written)
+				encoded, _ := event.MarshalJSON()
+				written += countingWriter(len(encoded))
+			}
+			data = data[size:]
+		}
+		if int(written) > limit {
+			t.Fatalf("%d bytes of output, over the limit of %d", written, limit)
+		}
+	})
+}
+
+// Counts the bytes written to it
+type countingWriter int
+
+func (n *countingWriter) Write(p []byte) (int, error) {
+	*n += countingWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,1171 @@
+package mysql
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns an event of type t with the given body, after a v4 header whose
+// event size is set
+func makeEvent(t EventType, body ...byte) []byte {
+	event := make([]byte, eventHeaderSize, eventHeaderSize + len(body))
+	event[4] = byte(t)
+	event = append(event, body...)
+	binary.LittleEndian.PutUint32(event[9:], uint32(len(event)))
+	return event
+}
+
+// Returns a copy of the event with its LogPos set
+func atLogPos(event []byte, pos uint32) []byte {
+	event = append([]byte{}, event...)
+	binary.LittleEndian.PutUint32(event[13:], pos)
+	return event
+}
+
+// Returns the QUERY_EVENT of query in the test database
+func makeQuery(query string) []byte {
+	body := []byte{1, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 't', 'e', 's', 't', 0}
+	return makeEvent(QUERY_EVENT, append(body, query...)...)
+}
+
+// Returns the FORMAT_DESCRIPTION_EVENT of a server of the given version whose
+// events use the checksum algorithm
+func makeFormatDescription(version string, checksum uint8) []byte {
+	body := make([]byte, 2 + 50 + 4 + 1)
+	body[0] = 4
+	copy(body[2:], version)
+	body[56] = eventHeaderSize
+	lengths := make([]byte, TRANSACTION_PAYLOAD_EVENT)
+	lengths[QUERY_EVENT - 1] = 13
+	lengths[ROTATE_EVENT - 1] = 8
+	lengths[TABLE_MAP_EVENT - 1] = 8
+	for _, t := range []EventType{WRITE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv1, DELETE_ROWS_EVENTv1} {
+		lengths[t - 1] = 8
+	}
+	for _, t := range []EventType{WRITE_ROWS_EVENTv2, UPDATE_ROWS_EVENTv2, DELETE_ROWS_EVENTv2} {
+		lengths[t - 1] = 10
+	}
+	lengths[GTID_EVENT - 1] = 42
+	body = append(body, lengths...)
+	body = append(body, checksum, 0, 0, 0, 0)
+	return makeEvent(FORMAT_DESCRIPTION_EVENT, body...)
+}
+
+// Returns a copy of the event followed by its CRC32, with the event size
+// counting it
+func checksummed(event []byte) []byte {
+	event = append([]byte{}, event...)
+	binary.LittleEndian.PutUint32(event[9:], uint32(len(event) + BINLOG_CHECKSUM_LEN))
+	return binary.LittleEndian.AppendUint32(event, crc32.ChecksumIEEE(event))
+}
+
+// Returns a ROTATE_EVENT to filename at position, flagged
+// LOG_EVENT_ARTIFICIAL_F if artificial
+func makeRotate(filename string, position uint64, artificial bool) []byte {
+	event := makeEvent(ROTATE_EVENT, append(binary.LittleEndian.AppendUint64(nil, position), filename...)...)
+	if artificial {
+		binary.LittleEndian.PutUint16(event[17:], uint16(LOG_EVENT_ARTIFICIAL_F))
+	}
+	return event
+}
+
+// Returns the TABLE_MAP_EVENT of test.table as table tableId, with the given
+// column types and metadata, all columns nullable, then the optional metadata
+func makeTableMap(tableId byte, table string, types, meta []byte, optional ...byte) []byte {
+	body := []byte{tableId, 0, 0, 0, 0, 0, 0, 0}
+	body = append(body, 4, 't', 'e', 's', 't', 0, byte(len(table)))
+	body = append(body, table...)
+	body = append(body, 0)
+	body = append(body, byte(len(types)))
+	body = append(body, types...)
+	body = append(body, byte(len(meta)))
+	body = append(body, meta...)
+	for i := 0; i < (len(types) + 7) / 8; i++ {
+		body = append(body, 0xff)
+	}
+	body = append(body, optional...)
+	return makeEvent(TABLE_MAP_EVENT, body...)
+}
+
+// Returns a v1 rows event of type t on table tableId, whose images have all
+// the columns, each row being its null bitmap and values
+func makeRowsEvent(t EventType, tableId byte, columns int, rows ...[]byte) []byte {
+	body := []byte{tableId, 0, 0, 0, 0, 0, 0, 0, byte(columns)}
+	bitmaps := 1
+	if t == UPDATE_ROWS_EVENTv1 {
+		bitmaps = 2
+	}
+	for i := 0; i < bitmaps * (columns + 7) / 8; i++ {
+		body = append(body, 0xff)
+	}
+	for _, row := range rows {
+		body = append(body, row...)
+	}
+	return makeEvent(t, body...)
+}
+
+// Columns id INT, name VARCHAR(10), e ENUM('a','b'), m MEDIUMINT, b BLOB,
+// dt DATETIME(0)
+var testTableMap = makeTableMap(1, "t",
+	[]byte{byte(FIELD_TYPE_LONG), byte(FIELD_TYPE_VARCHAR), byte(FIELD_TYPE_STRING), byte(FIELD_TYPE_INT24), byte(FIELD_TYPE_BLOB), byte(FIELD_TYPE_DATETIME2)},
+	[]byte{10, 0, byte(FIELD_TYPE_ENUM), 1, 2, 0},
+	TABLE_MAP_SIGNEDNESS, 1, 0x40,
+	TABLE_MAP_DEFAULT_CHARSET, 1, 8,
+	TABLE_MAP_COLUMN_NAME, 17, 2, 'i', 'd', 4, 'n', 'a', 'm', 'e', 1, 'e', 1, 'm', 1, 'b', 2, 'd', 't',
+	TABLE_MAP_ENUM_STR_VALUE, 5, 2, 1, 'a', 1, 'b',
+	TABLE_MAP_SIMPLE_PRIMARY_KEY, 1, 0)
+
+// A row of testTableMap: 1, "x", 'b', 16777215, "yz", 2022-06-01 12:30:00
+var testRow = []byte{0, 1, 0, 0, 0, 1, 'x', 2, 0xff, 0xff, 0xff, 2, 0, 'y', 'z', 0x99, 0xad, 0x02, 0xc7, 0x80}
+
+var testWriteRows = makeEvent(WRITE_ROWS_EVENTv2, append([]byte{1, 0, 0, 0, 0, 0, 1, 0, 2, 0, 6, 0x3f}, testRow...)...)
+
+var testUpdateRows = makeRowsEvent(UPDATE_ROWS_EVENTv1, 1, 6, testRow, testRow)
+
+// Parses with parser, or a new one if nil, an insert of rows (each its null
+// bitmap and values) into table 1, of the given column types and metadata and
+// then the optional metadata
+func parseTestRows(parser *Parser, types, meta, optional []byte, rows ...[]byte) ([][]driver.Value, error) {
+	if parser == nil {
+		parser = NewParser()
+	}
+	if _, err := parser.ParseEvent(makeTableMap(1, "t", types, meta, optional...)); err != nil {
+		return nil, err
+	}
+	event, err := parser.ParseEvent(makeRowsEvent(WRITE_ROWS_EVENTv1, 1, len(types), rows...))
+	if err != nil {
+		return nil, err
+	}
+	return event.(*RowsEvent).Rows(), nil
+}
+
+func TestParseRowsEventBlobWidths(t *testing.T) {
+	blob := []byte{byte(FIELD_TYPE_BLOB)}
+	for meta := 1; meta <= 4; meta++ {
+		// The length 3 in meta bytes
+		row := append([]byte{0, 3}, make([]byte, meta - 1)...)
+		rows, err := parseTestRows(nil, blob, []byte{byte(meta)}, nil, append(row, 'a', 'b', 'c'))
+		if err != nil {
+			t.Fatalf("meta %d: %v", meta, err)
+		}
+		if want := [][]driver.Value{{[]byte("abc")}}; !reflect.DeepEqual(rows, want) {
+			t.Errorf("meta %d: rows %q, want %q", meta, rows, want)
+		}
+	}
+	for _, meta := range []byte{0, 5} {
+		if _, err := parseTestRows(nil, blob, []byte{meta}, nil, []byte{0, 3, 0, 0, 0, 0, 'a', 'b', 'c'}); err == nil {
+			t.Errorf("BLOB with meta %d parsed", meta)
+		}
+	}
+}
+
+func TestFormatDescriptionHeaderLength(t *testing.T) {
+	event, err := newEventParser().parseEvent(makeFormatDescription("8.0.32-log", BINLOG_CHECKSUM_ALG_OFF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := event.(*FormatDescriptionEvent)
+	for _, c := range []struct {
+		t EventType
+		length uint8
+	}{
+		{TABLE_MAP_EVENT, 8},
+		{WRITE_ROWS_EVENTv1, 8},
+		{WRITE_ROWS_EVENTv2, 10},
+		{TRANSACTION_PAYLOAD_EVENT, 0},
+		// Out of the table
+		{UNKNOWN_EVENT, 0},
+		{TRANSACTION_PAYLOAD_EVENT + 1, 0},
+		{255, 0},
+	} {
+		if length := format.HeaderLength(c.t); length != c.length {
+			t.Errorf("header length of %s %d, want %d", c.t, length, c.length)
+		}
+	}
+	if length := (*FormatDescriptionEvent)(nil).HeaderLength(TABLE_MAP_EVENT); length != 0 {
+		t.Errorf("header length %d without format description, want 0", length)
+	}
+}
+
+func TestParseRowsEventBlobVariants(t *testing.T) {
+	for _, c := range []struct {
+		fieldType FieldType
+		meta []byte
+		prefix []byte
+	}{
+		// The sized BLOB types have fixed length prefixes and no meta
+		{FIELD_TYPE_TINY_BLOB, []byte{}, []byte{3}},
+		{FIELD_TYPE_MEDIUM_BLOB, []byte{}, []byte{3, 0, 0}},
+		{FIELD_TYPE_LONG_BLOB, []byte{}, []byte{3, 0, 0, 0}},
+		{FIELD_TYPE_BLOB, []byte{2}, []byte{3, 0}},
+		// VAR_STRING is like VARCHAR, a 2 byte length above 255 bytes
+		{FIELD_TYPE_VAR_STRING, []byte{10, 0}, []byte{3}},
+		{FIELD_TYPE_VAR_STRING, []byte{0x2c, 0x01}, []byte{3, 0}},
+		{FIELD_TYPE_VARCHAR, []byte{0x2c, 0x01}, []byte{3, 0}},
+	} {
+		row := append(append([]byte{0}, c.prefix...), 'a', 'b', 'c')
+		rows, err := parseTestRows(nil, []byte{byte(c.fieldType)}, c.meta, nil, row)
+		if err != nil {
+			t.Errorf("%s with meta % x: %v", fieldTypeName(c.fieldType), c.meta, err)
+		} else if want := [][]driver.Value{{[]byte("abc")}}; !reflect.DeepEqual(rows, want) {
+			t.Errorf("%s with meta % x: rows %q, want %q", fieldTypeName(c.fieldType), c.meta, rows, want)
+		}
+	}
+}
+
+func TestParseRowsEventChar300(t *testing.T) {
+	// CHAR(300) in latin1: 300 bytes, whose bit 8 is stored inverted in the
+	// type byte of the meta
+	types, meta := []byte{byte(FIELD_TYPE_STRING)}, []byte{0xee, 0x2c}
+	long := bytes.Repeat([]byte{'x'}, 300)
+	rows, err := parseTestRows(nil, types, meta, nil,
+		[]byte{0, 3, 0, 'a', 'b', 'c'},
+		append([]byte{0, 0x2c, 0x01}, long...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]driver.Value{{[]byte("abc")}, {long}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %q, want %q", rows, want)
+	}
+}
+
+func TestParseRowsEventFiltered(t *testing.T) {
+	parser := NewParser()
+	parser.SetTableFilter(func(schema, table string) bool {
+		return table == "kept"
+	})
+	types, meta := []byte{byte(FIELD_TYPE_LONG)}, []byte{}
+	for _, event := range [][]byte{makeTableMap(1, "skipped", types, meta), makeTableMap(2, "kept", types, meta)} {
+		if _, err := parser.ParseEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		event []byte
+		filtered bool
+	}{
+		{makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 1, []byte{0, 7, 0, 0, 0}), true},
+		// Without rows, but not filtered
+		{makeRowsEvent(WRITE_ROWS_EVENTv1, 2, 1), false},
+	} {
+		event, err := parser.ParseEvent(c.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := event.(*RowsEvent)
+		if rows.Rows() != nil || rows.Filtered() != c.filtered {
+			t.Errorf("%s: rows %v, filtered %v, want none and %v", rows.TableName(), rows.Rows(), rows.Filtered(), c.filtered)
+		}
+		encoded, _ := rows.MarshalJSON()
+		if bytes.Contains(encoded, []byte(`"filtered":true`)) != c.filtered {
+			t.Errorf("%s: JSON %s", rows.TableName(), encoded)
+		}
+	}
+}
+
+func TestParseRowsEventNoBlob(t *testing.T) {
+	parser := NewParser()
+	// id INT, body TEXT
+	if _, err := parser.ParseEvent(makeTableMap(1, "t", []byte{byte(FIELD_TYPE_LONG), byte(FIELD_TYPE_BLOB)}, []byte{2})); err != nil {
+		t.Fatal(err)
+	}
+	// binlog_row_image=NOBLOB leaves the unchanged TEXT out of the after
+	// images, and the after image null bitmaps have a single bit
+	body := []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0x03, 0x01}
+	body = append(body, 0, 1, 0, 0, 0, 4, 0, 't', 'e', 'x', 't')
+	body = append(body, 0, 2, 0, 0, 0)
+	body = append(body, 0x02, 3, 0, 0, 0)
+	body = append(body, 0, 4, 0, 0, 0)
+	event, err := parser.ParseEvent(makeEvent(UPDATE_ROWS_EVENTv1, body...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]driver.Value{
+		{int64(1), []byte("text")},
+		{int64(2), nil},
+		{int64(3), nil},
+		{int64(4), nil},
+	}
+	if rows := event.(*RowsEvent).Rows(); !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %v, want %v", rows, want)
+	}
+}
+
+// Returns a JSON column value of a row, with its 4 byte length
+func jsonColumn(value []byte) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(value))), value...)
+}
+
+func TestParsePartialUpdateRowsEvent(t *testing.T) {
+	parser := NewParser()
+	// id INT, j JSON
+	if _, err := parser.ParseEvent(makeTableMap(1, "t", []byte{byte(FIELD_TYPE_LONG), byte(FIELD_TYPE_JSON)}, []byte{4})); err != nil {
+		t.Fatal(err)
+	}
+	document := makeTestJSON(testJSONObject{{"a", testJSONObject{{"b", 1}}}})
+	diff := makeTestJSONDiff(JSON_DIFF_REPLACE, "$.a.b", 2)
+
+	// The after image starts with its value options, PARTIAL_JSON_UPDATES,
+	// and the partial bits of the JSON columns
+	body := []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 2, 0x03, 0x03}
+	body = append(body, 0, 1, 0, 0, 0)
+	body = append(body, jsonColumn(document)...)
+	body = append(body, PARTIAL_JSON_UPDATES, 0x01, 0, 1, 0, 0, 0)
+	body = append(body, jsonColumn(diff)...)
+	// Not partial, the whole document
+	body = append(body, 0, 2, 0, 0, 0)
+	body = append(body, jsonColumn(document)...)
+	body = append(body, 0, 0, 2, 0, 0, 0)
+	body = append(body, jsonColumn(makeTestJSON(3))...)
+	event, err := parser.ParseEvent(makeEvent(PARTIAL_UPDATE_ROWS_EVENT, body...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]driver.Value{
+		{int64(1), []byte(`{"a": {"b": 1}}`)},
+		{int64(1), []byte(`{"a": {"b": 2}}`)},
+		{int64(2), []byte(`{"a": {"b": 1}}`)},
+		{int64(2), []byte(`3`)},
+	}
+	if rows := event.(*RowsEvent).Rows(); !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %s, want %s", rows, want)
+	}
+	if op := event.(*RowsEvent).Operation(); op != ROWS_UPDATE {
+		t.Errorf("operation %s, want UPDATE", op)
+	}
+
+	// binlog_row_image=MINIMAL leaves the JSON out of the before image, so
+	// the diffs can't be applied
+	body = []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 2, 0x01, 0x03}
+	body = append(body, 0, 1, 0, 0, 0)
+	body = append(body, PARTIAL_JSON_UPDATES, 0x01, 0, 1, 0, 0, 0)
+	body = append(body, jsonColumn(diff)...)
+	if event, err = parser.ParseEvent(makeEvent(PARTIAL_UPDATE_ROWS_EVENT, body...)); err != nil {
+		t.Fatal(err)
+	}
+	want = [][]driver.Value{
+		{int64(1), nil},
+		{int64(1), []JSONDiff{{JSON_DIFF_REPLACE, "$.a.b", "2"}}},
+	}
+	if rows := event.(*RowsEvent).Rows(); !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %v, want %v", rows, want)
+	}
+}
+
+func TestParseTableMapEnumLabelCount(t *testing.T) {
+	types := []byte{byte(FIELD_TYPE_STRING)}
+	meta := []byte{byte(FIELD_TYPE_ENUM), 1}
+
+	event, err := newEventParser().parseEvent(makeTableMap(1, "t", types, meta,
+		TABLE_MAP_ENUM_STR_VALUE, 5, 2, 1, 'a', 1, 'b'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := event.(*TableMapEvent).Columns()[0].EnumValues
+	if len(labels) != 2 || labels[0] != "a" || labels[1] != "b" {
+		t.Fatalf("labels %q, want [a b]", labels)
+	}
+
+	// A label count of 2^64-1 in a 9 byte field
+	_, err = newEventParser().parseEvent(makeTableMap(1, "t", types, meta,
+		TABLE_MAP_ENUM_STR_VALUE, 9, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff))
+	var truncated *ErrTruncatedEvent
+	if !errors.As(err, &truncated) {
+		t.Fatalf("err %v, want ErrTruncatedEvent", err)
+	}
+}
+
+// Returns a PREVIOUS_GTIDS_EVENT of one interval from start to end, exclusive,
+// of the server 3e11fa47-71ca-11e1-9e33-c80aa9429562
+func makePreviousGTIDs(start, end uint64) []byte {
+	body := []byte{1, 0, 0, 0, 0, 0, 0, 0, 0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62, 1, 0, 0, 0, 0, 0, 0, 0}
+	body = binary.LittleEndian.AppendUint64(body, start)
+	return makeEvent(PREVIOUS_GTIDS_EVENT, binary.LittleEndian.AppendUint64(body, end)...)
+}
+
+func TestParsePreviousGTIDsIntervals(t *testing.T) {
+	event, err := newEventParser().parseEvent(makePreviousGTIDs(1, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set := event.(*PreviousGTIDsEvent).GTIDSet().String(); set != "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-7" {
+		t.Errorf("GTID set %s, want 3e11fa47-71ca-11e1-9e33-c80aa9429562:1-7", set)
+	}
+
+	// An end of 0 would make the interval end at 2^64-1
+	for _, interval := range [][2]uint64{{5, 5}, {5, 0}, {5, 3}} {
+		if _, err = newEventParser().parseEvent(makePreviousGTIDs(interval[0], interval[1])); err == nil {
+			t.Errorf("interval [%d, %d) parsed", interval[0], interval[1])
+		}
+	}
+}
+
+func TestParseGTIDEventTransactionLength(t *testing.T) {
+	// 3e11fa47-71ca-11e1-9e33-c80aa9429562:23, logical clock 6 and 7
+	body := []byte{1, 0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62, 23, 0, 0, 0, 0, 0, 0, 0,
+		2, 6, 0, 0, 0, 0, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0}
+	for _, c := range []struct {
+		body []byte
+		want uint64
+	}{
+		// MySQL 5.7 ends after the logical clock
+		{body, 0},
+		{append(append(body, 1, 2, 3, 4, 5, 6, 7), 200), 200},
+		// 8.0.14+ logs the server versions after the length
+		{append(append(body, 1, 2, 3, 4, 5, 6, 7), 0xfc, 0x2c, 0x01, 0x24, 0x9f, 0, 0), 300},
+		// The original commit timestamp follows when the high bit is set
+		{append(append(body, 1, 2, 3, 4, 5, 6, 0x80, 1, 2, 3, 4, 5, 6, 0), 0xfd, 0x40, 0x42, 0x0f), 1000000},
+	} {
+		event, err := newEventParser().parseEvent(makeEvent(GTID_EVENT, c.body...))
+		if err != nil {
+			t.Errorf("length %d: %v", c.want, err)
+			continue
+		}
+		gtid := event.(*GTIDEvent)
+		if gtid.GTID() != "3e11fa47-71ca-11e1-9e33-c80aa9429562:23" {
+			t.Errorf("GTID %s", gtid.GTID())
+		}
+		if length := gtid.TransactionLength(); length != c.want {
+			t.Errorf("transaction length %d, want %d", length, c.want)
+		}
+	}
+
+	// Cut in the middle of the length
+	if _, err := newEventParser().parseEvent(makeEvent(GTID_EVENT, append(append(body, 1, 2, 3, 4, 5, 6, 7), 0xfc, 0x2c)...)); err == nil {
+		t.Error("truncated transaction length parsed")
+	}
+}
+
+func TestParseChecksummedRowsEvent(t *testing.T) {
+	plain := NewParser()
+	if _, err := plain.ParseEvent(testTableMap); err != nil {
+		t.Fatal(err)
+	}
+	want, err := plain.ParseEvent(makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 6, testRow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CRC32 follows every event after the format description event
+	parser := NewParser()
+	for _, event := range [][]byte{makeFormatDescription("8.0.32-log", BINLOG_CHECKSUM_ALG_CRC32), checksummed(testTableMap)} {
+		if _, err = parser.ParseEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if algorithm := parser.ChecksumAlgorithm(); algorithm != BINLOG_CHECKSUM_ALG_CRC32 {
+		t.Errorf("checksum algorithm %d, want CRC32", algorithm)
+	}
+	rows := checksummed(makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 6, testRow))
+	event, err := parser.ParseEvent(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := event.(*RowsEvent).Rows(); !reflect.DeepEqual(got, want.(*RowsEvent).Rows()) {
+		t.Errorf("rows %v, want %v", got, want.(*RowsEvent).Rows())
+	}
+
+	rows[len(rows) - 1] ^= 0xff
+	var mismatch *ErrChecksumMismatch
+	if _, err = parser.ParseEvent(rows); !errors.As(err, &mismatch) {
+		t.Errorf("err %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestParseExecuteLoadQueryDupHandling(t *testing.T) {
+	query := "LOAD DATA INFILE '/tmp/SQL_LOAD-1-2-3.data' INTO TABLE t"
+	for _, want := range []LoadDupHandling{LOAD_DUP_ERROR, LOAD_DUP_IGNORE, LOAD_DUP_REPLACE} {
+		// File 3, its name at 17-42 of the query
+		body := []byte{1, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 3, 0, 0, 0, 17, 0, 0, 0, 42, 0, 0, 0, byte(want), 't', 'e', 's', 't', 0}
+		event, err := newEventParser().parseEvent(makeEvent(EXECUTE_LOAD_QUERY_EVENT, append(body, query...)...))
+		if err != nil {
+			t.Errorf("%s: %v", want, err)
+			continue
+		}
+		load := event.(*ExecuteLoadQueryEvent)
+		if dup := load.DupHandling(); dup != want {
+			t.Errorf("dup handling %s, want %s", dup, want)
+		}
+		if load.query != query || load.load.FileId != 3 || load.load.StartPos != 17 || load.load.EndPos != 42 {
+			t.Errorf("query %q, file %+v", load.query, load.load)
+		}
+	}
+	if name := LoadDupHandling(3).String(); name != "3" {
+		t.Errorf("unknown dup handling named %s", name)
+	}
+}
+
+func TestParseTableMapsAfterRotate(t *testing.T) {
+	parser := NewParser()
+	events := [][]byte{
+		makeTableMap(1, "t", []byte{byte(FIELD_TYPE_LONG)}, []byte{}),
+		makeRotate("binlog.000002", 4, false),
+	}
+	for _, event := range events {
+		if _, err := parser.ParseEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The id of test.t in the previous file
+	row := []byte{0, 3, 'a', 'b', 'c'}
+	if _, err := parser.ParseEvent(makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 1, row)); err == nil {
+		t.Fatal("rows event parsed with the table map of the previous file")
+	}
+
+	if _, err := parser.ParseEvent(makeTableMap(1, "u", []byte{byte(FIELD_TYPE_VARCHAR)}, []byte{10, 0})); err != nil {
+		t.Fatal(err)
+	}
+	event, err := parser.ParseEvent(makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 1, row))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := event.(*RowsEvent)
+	if rows.TableName() != "u" || string(rows.Rows()[0][0].([]byte)) != "abc" {
+		t.Errorf("row %v of table %s, want [abc] of u", rows.Rows(), rows.TableName())
+	}
+}
+
+func TestSupportedFieldTypes(t *testing.T) {
+	types := SupportedFieldTypes()
+	types[0] = FIELD_TYPE_NULL + 200
+	if IsFieldTypeSupported(FIELD_TYPE_NULL + 200) || !reflect.DeepEqual(SupportedFieldTypes()[1:], types[1:]) {
+		t.Fatal("SupportedFieldTypes returned its own slice")
+	}
+
+	// The list has to follow the decoders: rows of any other type fail with
+	// ErrUnsupportedFieldType, whatever the metadata
+	parser := newEventParser()
+	for i := 0; i < 256; i++ {
+		fieldType := FieldType(i)
+		metas := []uint16{0, 2, 255}
+		switch fieldType {
+		case FIELD_TYPE_DECIMAL:
+			// The registered length, see Column.DecimalLength
+			metas = []uint16{7}
+		case FIELD_TYPE_NEWDECIMAL:
+			// DECIMAL(10,2), table maps reject invalid precisions
+			metas = []uint16{0x020a}
+		case FIELD_TYPE_STRING:
+			// CHAR(10), the real type of other metas may be unsupported
+			metas = []uint16{10 << 8 | uint16(FIELD_TYPE_STRING)}
+		}
+		for _, meta := range metas {
+			tableMap := &TableMapEvent{columnTypes: []FieldType{fieldType}, columnMeta: []uint16{meta}}
+			row := bytes.NewBuffer(make([]byte, 1 + 64))
+			_, err := parser.parseEventRow(row, tableMap, Bitfield{1}, nil, nil)
+			var unsupported *ErrUnsupportedFieldType
+			if errors.As(err, &unsupported) == IsFieldTypeSupported(fieldType) {
+				t.Errorf("type %d with meta %#x: supported %v, decoding error %v", fieldType, meta, IsFieldTypeSupported(fieldType), err)
+			}
+		}
+	}
+}
+
+func TestParseRowsEventOldDecimal(t *testing.T) {
+	// d DECIMAL(5,2) of a table created by MySQL 4.1, as a 5.1 master logs
+	// it: no meta, values right-aligned in 7 bytes
+	decimal := []byte{byte(FIELD_TYPE_DECIMAL)}
+	registered := func() (*Parser) {
+		parser := NewParser()
+		parser.RegisterTable("test", "t", []Column{{Name: "d", DecimalLength: 7}})
+		return parser
+	}
+	rows, err := parseTestRows(registered(), decimal, []byte{}, nil,
+		append([]byte{0}, "  12.50"...),
+		append([]byte{0}, "-123.45"...),
+		append([]byte{0}, "   0.00"...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]driver.Value{{"12.50"}, {"-123.45"}, {"0.00"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %#v, want %#v", rows, want)
+	}
+
+	if _, err = parseTestRows(registered(), decimal, []byte{}, nil, append([]byte{0}, "  1x.00"...)); err == nil {
+		t.Errorf("invalid DECIMAL value decoded")
+	}
+	// Without the length the values can't be told apart
+	_, err = parseTestRows(nil, decimal, []byte{}, nil, append([]byte{0}, "  12.50"...))
+	if err == nil || !strings.Contains(err.Error(), "DecimalLength") {
+		t.Errorf("err %v, want the length missing", err)
+	}
+}
+
+func TestParseRowsEventInt24(t *testing.T) {
+	parser := NewParser()
+	// s MEDIUMINT, u MEDIUMINT UNSIGNED
+	tableMap := makeTableMap(1, "t", []byte{byte(FIELD_TYPE_INT24), byte(FIELD_TYPE_INT24)}, []byte{},
+		TABLE_MAP_SIGNEDNESS, 1, 0x40)
+	if _, err := parser.ParseEvent(tableMap); err != nil {
+		t.Fatal(err)
+	}
+	event, err := parser.ParseEvent(makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 2,
+		[]byte{0, 0x9c, 0xff, 0xff, 0xff, 0xff, 0xff},
+		[]byte{0, 0x00, 0x00, 0x80, 0x00, 0x00, 0x80},
+		[]byte{0, 0xff, 0xff, 0xff, 0x01, 0x00, 0x00}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]driver.Value{
+		{int64(-100), uint64(16777215)},
+		{int64(-8388608), uint64(8388608)},
+		{int64(-1), uint64(1)},
+	}
+	if got := event.(*RowsEvent).Rows(); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows %#v, want %#v", got, want)
+	}
+}
+
+func TestParseRowsEventBit(t *testing.T) {
+	for _, c := range []struct {
+		meta []byte
+		row []byte
+		want uint64
+	}{
+		// The meta is the bits past the full bytes, then the full bytes
+		{[]byte{1, 0}, []byte{0, 0x01}, 1},
+		{[]byte{1, 0}, []byte{0, 0x00}, 0},
+		{[]byte{0, 1}, []byte{0, 0xa5}, 0xa5},
+		{[]byte{1, 2}, []byte{0, 0x01, 0x23, 0x45}, 0x12345},
+		// Bits past the column's are masked off
+		{[]byte{1, 2}, []byte{0, 0xff, 0xff, 0xff}, 0x1ffff},
+		{[]byte{0, 8}, []byte{0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, math.MaxUint64 - 1},
+	} {
+		rows, err := parseTestRows(nil, []byte{byte(FIELD_TYPE_BIT)}, c.meta, nil, c.row)
+		if err != nil {
+			t.Errorf("BIT(%d) %#x: %v", int(c.meta[1]) * 8 + int(c.meta[0]), c.want, err)
+		} else if rows[0][0] != c.want {
+			t.Errorf("BIT(%d) %#x, want %#x", int(c.meta[1]) * 8 + int(c.meta[0]), rows[0][0], c.want)
+		}
+	}
+}
+
+func TestParseRowsEventEnum(t *testing.T) {
+	types := []byte{byte(FIELD_TYPE_STRING)}
+	for _, c := range []struct {
+		size byte
+		optional []byte
+		row []byte
+		want driver.Value
+	}{
+		// Without the labels, the 1-based index
+		{1, nil, []byte{0, 2}, int64(2)},
+		{2, nil, []byte{0, 0x2c, 0x01}, int64(300)},
+		{1, []byte{TABLE_MAP_ENUM_STR_VALUE, 5, 2, 1, 'a', 1, 'b'}, []byte{0, 2}, "b"},
+		// The empty string MySQL stores invalid values as
+		{1, []byte{TABLE_MAP_ENUM_STR_VALUE, 5, 2, 1, 'a', 1, 'b'}, []byte{0, 0}, ""},
+		{2, []byte{TABLE_MAP_ENUM_STR_VALUE, 5, 2, 1, 'a', 1, 'b'}, []byte{0, 0x01, 0x00}, "a"},
+	} {
+		rows, err := parseTestRows(nil, types, []byte{byte(FIELD_TYPE_ENUM), c.size}, c.optional, c.row)
+		if err != nil {
+			t.Errorf("%d byte ENUM %v: %v", c.size, c.want, err)
+		} else if rows[0][0] != c.want {
+			t.Errorf("%d byte ENUM %#v, want %#v", c.size, rows[0][0], c.want)
+		}
+	}
+	if _, err := parseTestRows(nil, types, []byte{byte(FIELD_TYPE_ENUM), 3}, nil, []byte{0, 1, 0, 0}); err == nil {
+		t.Error("3 byte ENUM decoded")
+	}
+}
+
+func TestParseRowsEventSet(t *testing.T) {
+	types, meta := []byte{byte(FIELD_TYPE_STRING)}, []byte{byte(FIELD_TYPE_SET), 1}
+	members := []byte{TABLE_MAP_SET_STR_VALUE, 17, 8, 1, 'a', 1, 'b', 1, 'c', 1, 'd', 1, 'e', 1, 'f', 1, 'g', 1, 'h'}
+	// Members 1, 3 and 8
+	row := []byte{0, 0x85}
+
+	rows, err := parseTestRows(nil, types, meta, nil, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0][0] != uint64(0x85) {
+		t.Errorf("SET without member names %#v, want 0x85", rows[0][0])
+	}
+	if rows, err = parseTestRows(nil, types, meta, members, row, []byte{0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if rows[0][0] != "a,c,h" || rows[1][0] != "" {
+		t.Errorf("SET values %#v and %#v, want \"a,c,h\" and \"\"", rows[0][0], rows[1][0])
+	}
+	if names := SetMembers(0x85, []string{"a", "b", "c", "d", "e", "f", "g", "h"}); !reflect.DeepEqual(names, []string{"a", "c", "h"}) {
+		t.Errorf("members %q, want [a c h]", names)
+	}
+}
+
+func TestParseRowsEventPoint(t *testing.T) {
+	// POINT(1 2) with SRID 4326: the SRID, then the WKB byte order, type 1
+	// and the little-endian coordinates
+	point := []byte{0xe6, 0x10, 0x00, 0x00, 0x01, 0x01, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40}
+	row := append([]byte{0, byte(len(point)), 0, 0, 0}, point...)
+	rows, err := parseTestRows(nil, []byte{byte(FIELD_TYPE_GEOMETRY)}, []byte{4}, nil, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := rows[0][0].([]byte); !ok || !reflect.DeepEqual(value, point) {
+		t.Errorf("POINT % x, want % x", rows[0][0], point)
+	}
+}
+
+func TestParseRowsEventVarcharNullOrEmpty(t *testing.T) {
+	// VARCHAR(10)
+	types, meta := []byte{byte(FIELD_TYPE_VARCHAR)}, []byte{10, 0}
+	for _, textAsString := range []bool{false, true} {
+		parser := NewParser()
+		parser.SetTextAsString(textAsString)
+		rows, err := parseTestRows(parser, types, meta, nil, []byte{1}, []byte{0, 0}, []byte{0, 1, 'a'})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := [][]driver.Value{{nil}, {[]byte{}}, {[]byte("a")}}
+		if textAsString {
+			want = [][]driver.Value{{nil}, {""}, {"a"}}
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("text as string %v: rows %#v, want %#v", textAsString, rows, want)
+		}
+	}
+
+	// A value cut short fails instead of decoding to NULL or ''
+	for _, row := range [][]byte{{0, 5, 'a'}, {0}} {
+		if rows, err := parseTestRows(nil, types, meta, nil, row); err == nil {
+			t.Errorf("row % x decoded to %#v", row, rows)
+		}
+	}
+}
+
+func TestParseRowsEventUnsignedInt(t *testing.T) {
+	// u INT UNSIGNED, s INT, both 4000000000 on the wire
+	types := []byte{byte(FIELD_TYPE_LONG), byte(FIELD_TYPE_LONG)}
+	row := []byte{0, 0x00, 0x28, 0x6b, 0xee, 0x00, 0x28, 0x6b, 0xee}
+	want := [][]driver.Value{{uint64(4000000000), int64(-294967296)}}
+
+	// The signedness is logged with binlog_row_metadata, or registered
+	rows, err := parseTestRows(nil, types, []byte{}, []byte{TABLE_MAP_SIGNEDNESS, 1, 0x80}, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %#v with the signedness logged, want %#v", rows, want)
+	}
+	parser := NewParser()
+	parser.RegisterTable("test", "t", []Column{{Name: "u", Unsigned: true}, {Name: "s"}})
+	if rows, err = parseTestRows(parser, types, []byte{}, nil, row); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %#v with the signedness registered, want %#v", rows, want)
+	}
+
+	// Without either, all integers are signed
+	if rows, err = parseTestRows(nil, types, []byte{}, nil, row); err != nil {
+		t.Fatal(err)
+	}
+	if want = [][]driver.Value{{int64(-294967296), int64(-294967296)}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %#v without signedness, want %#v", rows, want)
+	}
+}
+
+func TestParseRowsEventDate(t *testing.T) {
+	rows, err := parseTestRows(nil, []byte{byte(FIELD_TYPE_DATE)}, []byte{}, nil,
+		[]byte{0, 0xef, 0xce, 0x0f},
+		[]byte{0, 0x21, 0xd0, 0x07},
+		[]byte{0, 0x00, 0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]driver.Value{
+		{time.Date(2023, time.July, 15, 0, 0, 0, 0, time.UTC)},
+		{time.Date(1000, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// 0000-00-00
+		{time.Time{}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %v, want %v", rows, want)
+	}
+}
+
+func TestParseRowsEventDatetime(t *testing.T) {
+	datetime := func(digits int64) []byte {
+		return binary.LittleEndian.AppendUint64([]byte{0}, uint64(digits))
+	}
+	rows, err := parseTestRows(nil, []byte{byte(FIELD_TYPE_DATETIME)}, []byte{}, nil,
+		datetime(20230705080910),
+		datetime(99991231235959),
+		datetime(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]driver.Value{
+		// Single digit months, days, hours, minutes and seconds
+		{time.Date(2023, time.July, 5, 8, 9, 10, 0, time.UTC)},
+		{time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)},
+		// 0000-00-00 00:00:00
+		{time.Time{}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows %v, want %v", rows, want)
+	}
+}
+
+func TestParseRowsEventTimestamp(t *testing.T) {
+	parser := NewParser()
+	tokyo := time.FixedZone("JST", 9 * 60 * 60)
+	parser.SetLocation(tokyo)
+	rows, err := parseTestRows(parser, []byte{byte(FIELD_TYPE_TIMESTAMP)}, []byte{}, nil,
+		[]byte{0, 0x01, 0x00, 0x00, 0x00},
+		[]byte{0, 0xc8, 0x5b, 0x97, 0x62},
+		[]byte{0, 0xff, 0xff, 0xff, 0x7f},
+		[]byte{0, 0x00, 0x00, 0x00, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{
+		// The first second after the epoch, which is the zero timestamp
+		time.Date(1970, time.January, 1, 9, 0, 1, 0, tokyo),
+		time.Date(2022, time.June, 1, 21, 30, 0, 0, tokyo),
+		time.Date(2038, time.January, 19, 12, 14, 7, 0, tokyo),
+		// 0000-00-00 00:00:00
+		{},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("%d rows, want %d", len(rows), len(want))
+	}
+	for i, row := range rows {
+		value := row[0].(time.Time)
+		if !value.Equal(want[i]) || value.Location() != want[i].Location() {
+			t.Errorf("timestamp %v, want %v", value, want[i])
+		}
+	}
+}
+
+func TestParseRowsEventTimestamp2(t *testing.T) {
+	for _, c := range []struct {
+		fsp byte
+		row []byte
+		want time.Time
+	}{
+		{0, []byte{0, 0x62, 0x97, 0x5b, 0xc8}, time.Date(2022, time.June, 1, 12, 30, 0, 0, time.UTC)},
+		{0, []byte{0, 0x00, 0x00, 0x00, 0x01}, time.Date(1970, time.January, 1, 0, 0, 1, 0, time.UTC)},
+		{0, []byte{0, 0x00, 0x00, 0x00, 0x00}, time.Time{}},
+		// Hundreds of microseconds in 2 bytes
+		{3, []byte{0, 0x62, 0x97, 0x5b, 0xc8, 0x04, 0xce}, time.Date(2022, time.June, 1, 12, 30, 0, 123000000, time.UTC)},
+		{3, []byte{0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, time.Time{}},
+		{6, []byte{0, 0x62, 0x97, 0x5b, 0xc8, 0x01, 0xe2, 0x40}, time.Date(2022, time.June, 1, 12, 30, 0, 123456000, time.UTC)},
+		// Only the fractional part is zero at the epoch
+		{6, []byte{0, 0x00, 0x00, 0x00, 0x00, 0x07, 0xa1, 0x20}, time.Date(1970, time.January, 1, 0, 0, 0, 500000000, time.UTC)},
+	} {
+		rows, err := parseTestRows(nil, []byte{byte(FIELD_TYPE_TIMESTAMP2)}, []byte{c.fsp}, nil, c.row)
+		if err != nil {
+			t.Errorf("fsp %d, %v: %v", c.fsp, c.want, err)
+			continue
+		}
+		if value := rows[0][0].(time.Time); !value.Equal(c.want) || value.IsZero() != c.want.IsZero() {
+			t.Errorf("fsp %d: timestamp %v, want %v", c.fsp, value, c.want)
+		}
+	}
+
+	// The fractional part is missing
+	if _, err := parseTestRows(nil, []byte{byte(FIELD_TYPE_TIMESTAMP2)}, []byte{6}, nil, []byte{0, 0x62, 0x97, 0x5b, 0xc8, 0x01}); err == nil {
+		t.Error("truncated TIMESTAMP(6) decoded")
+	}
+}
+
+func TestParseRowsEventTime2(t *testing.T) {
+	for _, c := range []struct {
+		fsp byte
+		row []byte
+		want string
+	}{
+		{0, []byte{0, 0x4b, 0x91, 0x05}, "-838:59:59"},
+		{0, []byte{0, 0xb4, 0x6e, 0xfb}, "838:59:59"},
+		{0, []byte{0, 0x80, 0x00, 0x00}, "00:00:00"},
+		{3, []byte{0, 0x80, 0x10, 0x00, 0x04, 0xce}, "01:00:00.123"},
+		{6, []byte{0, 0x80, 0xc8, 0xb8, 0x0c, 0x0a, 0x14}, "12:34:56.789012"},
+		// The integral part is one less, the fraction complemented
+		{2, []byte{0, 0x7f, 0xff, 0xfe, 0xe7}, "-00:00:01.25"},
+	} {
+		rows, err := parseTestRows(nil, []byte{byte(FIELD_TYPE_TIME2)}, []byte{c.fsp}, nil, c.row)
+		if err != nil {
+			t.Errorf("%s: %v", c.want, err)
+		} else if rows[0][0] != c.want {
+			t.Errorf("TIME(%d) %v, want %s", c.fsp, rows[0][0], c.want)
+		}
+	}
+}
+
+func TestParseEventReusedBuffer(t *testing.T) {
+	parser := NewParser()
+	if _, err := parser.ParseEvent(testTableMap); err != nil {
+		t.Fatal(err)
+	}
+	buf := append([]byte(nil), testWriteRows...)
+	rows, err := parser.ParseEvent(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overwrite the buffer with the next event, as a reader reusing it would
+	buf = append(buf[:0], makeEvent(0xfe, 1, 2, 3)...)
+	generic, err := parser.ParseEvent(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf = buf[:cap(buf)]
+	for i := range buf {
+		buf[i] = 0
+	}
+	if blob := rows.(*RowsEvent).Rows()[0][4]; !bytes.Equal(blob.([]byte), []byte("yz")) {
+		t.Errorf("BLOB value %q, want \"yz\"", blob)
+	}
+	if data := generic.(*GenericEvent).data; !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Errorf("GenericEvent data %v, want [1 2 3]", data)
+	}
+}
+
+func TestParseUpdateRowsEventPartialAfterImage(t *testing.T) {
+	// a, b, c INT, the after images only hold b
+	tableMap := makeTableMap(1, "t", []byte{byte(FIELD_TYPE_LONG), byte(FIELD_TYPE_LONG), byte(FIELD_TYPE_LONG)}, []byte{},
+		TABLE_MAP_COLUMN_NAME, 6, 1, 'a', 1, 'b', 1, 'c')
+	body := []byte{1, 0, 0, 0, 0, 0, 1, 0, 2, 0, 3, 0x07, 0x02}
+	for i := byte(1); i <= 2; i++ {
+		body = append(body, 0, i, 0, 0, 0, 10 * i, 0, 0, 0, 100 + i, 0, 0, 0)
+		body = append(body, 0, 20 * i, 0, 0, 0)
+	}
+	rows := makeEvent(UPDATE_ROWS_EVENTv2, body...)
+
+	for _, raw := range []bool{false, true} {
+		parser := NewParser()
+		parser.SetRawMode(raw)
+		if _, err := parser.ParseEvent(tableMap); err != nil {
+			t.Fatal(err)
+		}
+		event, err := parser.ParseEvent(rows)
+		if err != nil {
+			t.Fatalf("raw %v: %v", raw, err)
+		}
+		rowsEvent := event.(*RowsEvent)
+		if raw {
+			want := [][][]byte{
+				{{1, 0, 0, 0}, {10, 0, 0, 0}, {101, 0, 0, 0}}, {nil, {20, 0, 0, 0}, nil},
+				{{2, 0, 0, 0}, {20, 0, 0, 0}, {102, 0, 0, 0}}, {nil, {40, 0, 0, 0}, nil},
+			}
+			if got := rowsEvent.RawColumns(); !reflect.DeepEqual(got, want) {
+				t.Errorf("raw columns %v, want %v", got, want)
+			}
+			continue
+		}
+		want := []map[string]driver.Value{
+			{"a": int64(1), "b": int64(10), "c": int64(101)}, {"b": int64(20)},
+			{"a": int64(2), "b": int64(20), "c": int64(102)}, {"b": int64(40)},
+		}
+		if got := rowsEvent.RowMaps(); !reflect.DeepEqual(got, want) {
+			t.Errorf("rows %v, want %v", got, want)
+		}
+	}
+}
+
+func TestParseTransactionPayloadTooLarge(t *testing.T) {
+	const compression = 7
+	called := false
+	RegisterDecompressor(compression, func(data []byte, size uint64) ([]byte, error) {
+		called = true
+		return nil, errors.New("Decompressed")
+	})
+	// Compression type, uncompressed size, end mark, payload
+	body := []byte{2, 1, compression, 3, 9, 0xfe}
+	body = binary.LittleEndian.AppendUint64(body, MAX_UNCOMPRESSED_PAYLOAD_SIZE + 1)
+	body = append(body, 0, 'x')
+	_, err := NewParser().ParseEvent(makeEvent(TRANSACTION_PAYLOAD_EVENT, body...))
+	if err == nil || called {
+		t.Errorf("err %v, decompressor called %v, want an error before decompressing", err, called)
+	}
+}
+
+func TestParseRowsEventV0(t *testing.T) {
+	// A 5.1.15 master logs v0 rows events, with 4-byte table ids if the
+	// post-header length is 6 and 6-byte ones if it's 8
+	types, row := []byte{byte(FIELD_TYPE_LONG)}, func(v byte) []byte { return []byte{0, v, 0, 0, 0} }
+	for _, width := range []int{4, 6} {
+		fde := makeFormatDescription("5.1.15-log", BINLOG_CHECKSUM_ALG_OFF)
+		lengths := fde[eventHeaderSize + 57:]
+		for _, t := range []EventType{TABLE_MAP_EVENT, WRITE_ROWS_EVENTv0, UPDATE_ROWS_EVENTv0, DELETE_ROWS_EVENTv0} {
+			lengths[t - 1] = byte(width + 2)
+		}
+		// The events of the helpers with their 6-byte table id replaced
+		tableId := []byte{1, 2, 3, 4, 0, 0}[:width]
+		withTableId := func(event []byte) []byte {
+			return makeEvent(EventType(event[4]), append(append([]byte{}, tableId...), event[eventHeaderSize + 6:]...)...)
+		}
+		parser := NewParser()
+		for _, event := range [][]byte{fde, withTableId(makeTableMap(1, "t", types, []byte{}))} {
+			if _, err := parser.ParseEvent(event); err != nil {
+				t.Fatalf("%d-byte table id: %v", width, err)
+			}
+		}
+		for _, c := range []struct {
+			event []byte
+			want [][]driver.Value
+		}{
+			{makeRowsEvent(WRITE_ROWS_EVENTv0, 1, 1, row(7)), [][]driver.Value{{int64(7)}}},
+			// A single bitmap for both images
+			{makeRowsEvent(UPDATE_ROWS_EVENTv0, 1, 1, row(7), row(8)), [][]driver.Value{{int64(7)}, {int64(8)}}},
+			{makeRowsEvent(DELETE_ROWS_EVENTv0, 1, 1, row(8)), [][]driver.Value{{int64(8)}}},
+		} {
+			event, err := parser.ParseEvent(withTableId(c.event))
+			if err != nil {
+				t.Fatalf("%d-byte table id, %s: %v", width, EventType(c.event[4]), err)
+			}
+			rows := event.(*RowsEvent)
+			if rows.tableId != 0x04030201 || !reflect.DeepEqual(rows.Rows(), c.want) {
+				t.Errorf("%d-byte table id, %s: table %#x rows %v, want table 0x4030201 rows %v", width, EventType(c.event[4]), rows.tableId, rows.Rows(), c.want)
+			}
+		}
+	}
+}
+
+func TestParseRowsEventRowAllocator(t *testing.T) {
+	parser := NewParser()
+	if _, err := parser.ParseEvent(testTableMap); err != nil {
+		t.Fatal(err)
+	}
+	var allocated [][]driver.Value
+	parser.SetRowAllocator(func(columnsCount int) []driver.Value {
+		// Values left from a previous use
+		row := []driver.Value{"stale", "stale", "stale", "stale", "stale", "stale", "stale"}
+		allocated = append(allocated, row)
+		return row
+	})
+	// Columns 1 and 2 of the after image are missing
+	rows := makeEvent(UPDATE_ROWS_EVENTv1, append(append([]byte{1, 0, 0, 0, 0, 0, 0, 0, 6, 0x3f, 0x39}, testRow...),
+		0, 2, 0, 0, 0, 0xff, 0xff, 0xff, 2, 0, 'y', 'z', 0x99, 0xad, 0x02, 0xc7, 0x80)...)
+	event, err := parser.ParseEvent(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := event.(*RowsEvent).Rows()
+	if len(allocated) != 2 || &got[0][0] != &allocated[0][0] || &got[1][0] != &allocated[1][0] {
+		t.Fatalf("rows not from the allocator")
+	}
+	if len(got[1]) != 6 || got[1][1] != nil || got[1][2] != nil || got[1][0] != int64(2) {
+		t.Errorf("after image %#v, want the missing columns cleared", got[1])
+	}
+}
+
+func TestNewRowSlabAllocator(t *testing.T) {
+	tableMap, rows := benchmarkRowsEvents()
+	var want [][]driver.Value
+	for _, slab := range []bool{false, true} {
+		parser := NewParser()
+		if slab {
+			parser.SetRowAllocator(NewRowSlabAllocator())
+		}
+		if _, err := parser.ParseEvent(tableMap); err != nil {
+			t.Fatal(err)
+		}
+		event, err := parser.ParseEvent(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := event.(*RowsEvent).Rows()
+		if !slab {
+			want = got
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("slab rows differ from the default rows")
+		}
+		for i, row := range got {
+			if cap(row) != 5 {
+				t.Fatalf("row %d cap %d, want 5", i, cap(row))
+			}
+		}
+	}
+	alloc := NewRowSlabAllocator()
+	first, second := alloc(3), alloc(2)
+	first[0] = "x"
+	if second[0] != nil || &first[:3][2] == &second[0] {
+		t.Errorf("slab rows overlap")
+	}
+}
+
+// An insert of 1000 rows of (INT, INT NULL, VARCHAR(20), BIGINT, DOUBLE),
+// every other one with a NULL
+func benchmarkRowsEvents() (tableMap, rows []byte) {
+	tableMap = makeTableMap(1, "bench",
+		[]byte{byte(FIELD_TYPE_LONG), byte(FIELD_TYPE_LONG), byte(FIELD_TYPE_VARCHAR), byte(FIELD_TYPE_LONGLONG), byte(FIELD_TYPE_DOUBLE)},
+		[]byte{20, 0, 8})
+	var data []byte
+	for i := 0; i < 1000; i++ {
+		if i % 2 == 0 {
+			data = append(data, 0)
+			data = binary.LittleEndian.AppendUint32(data, uint32(i))
+			data = binary.LittleEndian.AppendUint32(data, uint32(i))
+		} else {
+			data = append(data, 2)
+			data = binary.LittleEndian.AppendUint32(data, uint32(i))
+		}
+		data = append(data, 5, 'h', 'e', 'l', 'l', 'o')
+		data = binary.LittleEndian.AppendUint64(data, uint64(i) << 20)
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(float64(i)))
+	}
+	return tableMap, makeRowsEvent(WRITE_ROWS_EVENTv1, 1, 5, data)
+}
+
+func benchmarkParseRowsEvent(b *testing.B, parser *Parser, done func(rows [][]driver.Value)) {
+	tableMap, rows := benchmarkRowsEvents()
+	if _, err := parser.ParseEvent(tableMap); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(rows)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event, err := parser.ParseEvent(rows)
+		if err != nil {
+			b.Fatal(err)
+		}
+		done(event.(*RowsEvent).Rows())
+	}
+}
+
+func BenchmarkParseRowsEvent(b *testing.B) {
+	benchmarkParseRowsEvent(b, NewParser(), func(rows [][]driver.Value) {})
+}
+
+func BenchmarkParseRowsEventSlab(b *testing.B) {
+	parser := NewParser()
+	parser.SetRowAllocator(NewRowSlabAllocator())
+	benchmarkParseRowsEvent(b, parser, func(rows [][]driver.Value) {})
+}
+
+func BenchmarkParseRowsEventRowAllocator(b *testing.B) {
+	// The rows of the previous event
+	var free [][]driver.Value
+	parser := NewParser()
+	parser.SetRowAllocator(func(columnsCount int) []driver.Value {
+		if n := len(free); n > 0 {
+			row := free[n - 1]
+			free = free[:n - 1]
+			return row
+		}
+		return make([]driver.Value, columnsCount)
+	})
+	benchmarkParseRowsEvent(b, parser, func(rows [][]driver.Value) {
+		free = append(free, rows...)
+	})
+}
+
+func BenchmarkParseRowsEventRaw(b *testing.B) {
+	parser := NewParser()
+	parser.SetRawMode(true)
+	benchmarkParseRowsEvent(b, parser, func(rows [][]driver.Value) {})
+}
@@ -0,0 +1,73 @@
+package mysql
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Every binlog file starts with these 4 bytes
+var binlogFileMagic = []byte{0xfe, 'b', 'i', 'n'}
+
+// ErrNotBinlogFile is returned for files that don't start with the binlog
+// magic number
+type ErrNotBinlogFile struct {
+	// The bytes the file starts with instead, fewer than 4 if it is shorter
+	Magic []byte
+}
+
+func (e *ErrNotBinlogFile) Error() string {
+	return fmt.Sprintf("Not a binlog file: it starts with %x instead of %x", e.Magic, binlogFileMagic)
+}
+
+// ReadBinlogFileHeader reads the magic number binlog files start with from r
+// and returns an ErrNotBinlogFile if it doesn't match. r is then positioned at
+// the first event, ready for NewReader.
+func ReadBinlogFileHeader(r io.Reader) error {
+	magic := make([]byte, len(binlogFileMagic))
+	n, e := io.ReadFull(r, magic)
+	if e == io.EOF || e == io.ErrUnexpectedEOF {
+		return &ErrNotBinlogFile{Magic: magic[:n]}
+	} else if e != nil {
+		return e
+	}
+	if !bytes.Equal(magic, binlogFileMagic) {
+		return &ErrNotBinlogFile{Magic: magic}
+	}
+	return nil
+}
+
+// ParseFile parses a binlog file, as found in the server's data directory or
+// saved by mysqlbinlog --raw, and calls handler with every event in order. It
+// stops at the end of the file or at the first error, either from parsing or
+// returned by handler. Files not starting with the binlog magic number fail
+// with an ErrNotBinlogFile.
+func ParseFile(path string, handler func(BinlogEvent) error) error {
+	f, e := os.Open(path)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	if e = ReadBinlogFileHeader(r); e != nil {
+		return e
+	}
+
+	reader := NewReader(r)
+	reader.SetPosition(filepath.Base(path), uint32(len(binlogFileMagic)))
+	for {
+		event, e := reader.Next()
+		if e == io.EOF {
+			return nil
+		} else if e != nil {
+			return e
+		}
+		if e = handler(event); e != nil {
+			return e
+		}
+	}
+}
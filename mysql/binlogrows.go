@@ -0,0 +1,96 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+)
+
+// Columns of the rows returned by DumpBinlog, which describes them
+var binlogRowsColumns = []string{"log_pos", "timestamp", "schema", "table", "action", "before", "after"}
+
+// driver.Rows of the row changes of a binlog dump, see DumpBinlog
+type binlogRows struct {
+	events chan BinlogEvent
+	cancel func()
+	// Set by the dump before it closes events
+	dumpErr error
+	stream *ChangeStream
+	// Row changes of the last event not returned yet
+	changes []RowChange
+	// Returned by Next once the dump ended
+	err error
+}
+
+// Starts a dump from filename at position whose row changes the returned rows
+// yield
+func (mc *mysqlConn) newBinlogRows(ctx context.Context, filename string, position uint32) (*binlogRows) {
+	ctx, cancel := context.WithCancel(ctx)
+	rows := &binlogRows{events: make(chan BinlogEvent), cancel: cancel, stream: NewChangeStream()}
+	rows.stream.OnRowChange(func(change RowChange) error {
+		rows.changes = append(rows.changes, change)
+		return nil
+	})
+	go func() {
+		ServerId := uint32(1) // Must be non-zero to avoid getting EOF packet
+		rows.dumpErr = mc.DumpBinlogTo(ctx, ServerId, filename, position, rows.events)
+		close(rows.events)
+	}()
+	return rows
+}
+
+func (rows *binlogRows) Columns() ([]string) {
+	return append([]string{}, binlogRowsColumns...)
+}
+
+// Close stops the dump if it is still running, which leaves the connection
+// unusable, see DumpBinlogTo
+func (rows *binlogRows) Close() error {
+	rows.cancel()
+	for range rows.events {
+	}
+	return nil
+}
+
+// Next waits for the next row change of the dump. It returns io.EOF once the
+// master ended the dump, or the error the dump failed with.
+func (rows *binlogRows) Next(dest []driver.Value) error {
+	for len(rows.changes) == 0 {
+		if rows.err != nil {
+			return rows.err
+		}
+		event, ok := <-rows.events
+		if !ok {
+			if rows.err = rows.dumpErr; rows.err == nil {
+				rows.err = io.EOF
+			}
+			continue
+		}
+		if e := rows.stream.Process(event); e != nil {
+			return e
+		}
+	}
+	change := rows.changes[0]
+	rows.changes = rows.changes[1:]
+
+	before, e := marshalRowImage(change.Before)
+	if e != nil {
+		return e
+	}
+	after, e := marshalRowImage(change.After)
+	if e != nil {
+		return e
+	}
+	values := []driver.Value{int64(change.LogPos), change.Timestamp, change.Schema, change.Table, change.Action.String(), before, after}
+	copy(dest, values)
+	return nil
+}
+
+// Encodes the values of a row as a JSON array, nil for no row
+func marshalRowImage(row []driver.Value) (driver.Value, error) {
+	if row == nil {
+		return nil, nil
+	}
+	return json.Marshal(row)
+}
@@ -0,0 +1,102 @@
+package mysql
+
+import (
+	"sync"
+	"unicode/utf8"
+)
+
+// CharsetDecoder transcodes text in a character set to UTF-8
+type CharsetDecoder func(data []byte) ([]byte, error)
+
+// Decoders registered with RegisterCharsetDecoder, by collation id
+var (
+	charsetDecoders     = make(map[uint16]CharsetDecoder)
+	charsetDecodersLock sync.RWMutex
+)
+
+// Collation ids of the latin1 character set, decoded by default
+var latin1Collations = []uint16{5, 8, 15, 31, 47, 48, 49, 94}
+
+func init() {
+	for _, collation := range latin1Collations {
+		charsetDecoders[collation] = decodeLatin1
+	}
+}
+
+// RegisterCharsetDecoder registers the decoder of the text of columns with the
+// given collation id, see Column.Charset. With SetTextAsString, the values of
+// CHAR, VARCHAR and TEXT columns whose collation has a decoder are transcoded
+// to UTF-8; latin1 is decoded by default, other collations are assumed to be
+// UTF-8 already. The package has no dependencies, so other character sets have
+// to be registered, e.g. gbk (gbk_chinese_ci and gbk_bin) with
+// golang.org/x/text/encoding/simplifiedchinese:
+//	decode := func(data []byte) ([]byte, error) {
+//		return simplifiedchinese.GBK.NewDecoder().Bytes(data)
+//	}
+//	mysql.RegisterCharsetDecoder(28, decode)
+//	mysql.RegisterCharsetDecoder(87, decode)
+// A nil decoder removes the collation's. Decoders are looked up when a table
+// map is parsed, so they apply to the tables mapped afterwards.
+func RegisterCharsetDecoder(collation uint16, decode CharsetDecoder) {
+	charsetDecodersLock.Lock()
+	if decode == nil {
+		delete(charsetDecoders, collation)
+	} else {
+		charsetDecoders[collation] = decode
+	}
+	charsetDecodersLock.Unlock()
+}
+
+// Returns the decoders of the character columns of tableMap, by column index,
+// or nil if none has one
+func lookupCharsetDecoders(tableMap *TableMapEvent) (decoders []CharsetDecoder) {
+	charsetDecodersLock.RLock()
+	defer charsetDecodersLock.RUnlock()
+	for i, column := range tableMap.columns {
+		if i >= len(tableMap.columnTypes) || !isCharacterType(tableMap.columnTypes[i]) {
+			continue
+		}
+		if decode := charsetDecoders[column.Charset]; decode != nil {
+			if decoders == nil {
+				decoders = make([]CharsetDecoder, len(tableMap.columns))
+			}
+			decoders[i] = decode
+		}
+	}
+	return
+}
+
+// MySQL's latin1 is cp1252, whose 0x80-0x9f are these characters, with the 5
+// bytes cp1252 leaves undefined mapped to the same code points
+var latin1HighControls = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+func decodeLatin1(data []byte) ([]byte, error) {
+	ascii := true
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return data, nil
+	}
+
+	decoded := make([]byte, 0, len(data) * 2)
+	for _, b := range data {
+		switch {
+		case b < utf8.RuneSelf:
+			decoded = append(decoded, b)
+		case b < 0xa0:
+			decoded = utf8.AppendRune(decoded, latin1HighControls[b - 0x80])
+		default:
+			decoded = utf8.AppendRune(decoded, rune(b))
+		}
+	}
+	return decoded, nil
+}
@@ -10,10 +10,12 @@ package mysql
 
 import (
 	"bufio"
+	"crypto/tls"
 	"database/sql/driver"
 	"errors"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -28,6 +30,15 @@ type mysqlConn struct {
 	insertId       uint64
 	lastCmdTime    time.Time
 	keepaliveTimer *time.Timer
+	semiSync       bool
+	binlogTimeout  time.Duration
+	dumpLock       sync.Mutex
+	dumpCancel     func()
+	dumpDone       chan struct{}
+	dumpParser     *eventParser
+	parser         *Parser
+	metrics        DumpMetrics
+	logger         Logger
 }
 
 type config struct {
@@ -37,6 +48,7 @@ type config struct {
 	addr   string
 	dbname string
 	params map[string]string
+	tls    *tls.Config
 }
 
 type serverSettings struct {
@@ -60,13 +72,13 @@ func (mc *mysqlConn) handleParams() (e error) {
 				return
 			}
 
-		// TLS-Encryption
+		// TLS-Encryption, set up during the handshake
 		case "tls":
-			dbgLog.Print("TLS-Encryption not implemented yet")
+			continue
 
 		// Compression
 		case "compress":
-			dbgLog.Print("Compression not implemented yet")
+			mc.log().Infof("Compression not implemented yet, ignoring the compress parameter")
 
 		// We don't want to set keepalive as system var
 		case "keepalive":
@@ -71,6 +71,8 @@ const (
 	COM_STMT_RESET
 	COM_SET_OPTION
 	COM_STMT_FETCH
+	COM_DAEMON
+	COM_BINLOG_DUMP_GTID
 )
 
 type FieldType byte
@@ -93,9 +95,13 @@ const (
 	FIELD_TYPE_NEWDATE
 	FIELD_TYPE_VARCHAR
 	FIELD_TYPE_BIT
+	FIELD_TYPE_TIMESTAMP2
+	FIELD_TYPE_DATETIME2
+	FIELD_TYPE_TIME2
 )
 const (
-	FIELD_TYPE_NEWDECIMAL FieldType = iota + 0xf6
+	FIELD_TYPE_JSON FieldType = iota + 0xf5
+	FIELD_TYPE_NEWDECIMAL
 	FIELD_TYPE_ENUM
 	FIELD_TYPE_SET
 	FIELD_TYPE_TINY_BLOB
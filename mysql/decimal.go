@@ -0,0 +1,94 @@
+package mysql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bytes needed to store 0-9 decimal digits in the packed DECIMAL format
+var decimalDigitBytes = [10]int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// Returns the size of a packed DECIMAL(precision, scale) value. Each full group
+// of nine digits takes 4 bytes, leftover digits take up to 4 bytes.
+func decimalBinarySize(precision, scale int) int {
+	integral := precision - scale
+	return integral/9*4 + decimalDigitBytes[integral%9] + scale/9*4 + decimalDigitBytes[scale%9]
+}
+
+// Reads a big-endian unsigned integer
+func bytesToUintBE(b []byte) (n uint64) {
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return
+}
+
+// DecodeDecimal decodes a packed DECIMAL(precision, scale), as stored in rows
+// events, into its canonical string, e.g. "-123.4500". Digits are split at the
+// decimal point and stored big-endian in groups of nine per 4 bytes, with
+// leftover integral digits first and leftover fractional digits last:
+//
+//	Bytes                        Name
+//	-----                        ----
+//	0-4                          leading integral digits ((precision-scale) % 9)
+//	4*n                          integral groups of 9 digits
+//	4*n                          fractional groups of 9 digits
+//	0-4                          trailing fractional digits (scale % 9)
+//
+// The sign bit of the first byte is inverted so values sort bytewise, and all
+// bytes of negative values are inverted.
+func DecodeDecimal(data []byte, precision, scale int) (string, error) {
+	if precision < 1 || scale < 0 || scale > precision {
+		return "", fmt.Errorf("Invalid DECIMAL(%d,%d)", precision, scale)
+	}
+	size := decimalBinarySize(precision, scale)
+	if len(data) != size {
+		return "", fmt.Errorf("Invalid DECIMAL(%d,%d) length %d, expected %d", precision, scale, len(data), size)
+	}
+
+	// Don't modify the caller's data
+	b := make([]byte, size)
+	copy(b, data)
+
+	negative := b[0]&0x80 == 0
+	b[0] ^= 0x80
+	if negative {
+		for i := range b {
+			b[i] ^= 0xff
+		}
+	}
+
+	integral := precision - scale
+	var intPart, fracPart []string
+	pos := 0
+
+	if n := decimalDigitBytes[integral%9]; n > 0 {
+		intPart = append(intPart, strconv.FormatUint(bytesToUintBE(b[pos:pos+n]), 10))
+		pos += n
+	}
+	for i := 0; i < integral/9; i++ {
+		intPart = append(intPart, fmt.Sprintf("%09d", bytesToUintBE(b[pos:pos+4])))
+		pos += 4
+	}
+	for i := 0; i < scale/9; i++ {
+		fracPart = append(fracPart, fmt.Sprintf("%09d", bytesToUintBE(b[pos:pos+4])))
+		pos += 4
+	}
+	if digits := scale % 9; digits > 0 {
+		n := decimalDigitBytes[digits]
+		fracPart = append(fracPart, fmt.Sprintf("%0*d", digits, bytesToUintBE(b[pos:pos+n])))
+	}
+
+	value := strings.TrimLeft(strings.Join(intPart, ""), "0")
+	if value == "" {
+		value = "0"
+	}
+	if scale > 0 {
+		value += "." + strings.Join(fracPart, "")
+	}
+	if negative {
+		value = "-" + value
+	}
+	return value, nil
+}
@@ -0,0 +1,49 @@
+package mysql
+
+import (
+	"testing"
+)
+
+func TestDecodeDecimal(t *testing.T) {
+	for _, c := range []struct {
+		data []byte
+		precision, scale int
+		want string
+	}{
+		// The example of the MySQL manual: 1 leading digit, a group of 9, and
+		// 4 trailing digits in 2 bytes
+		{[]byte{0x81, 0x0d, 0xfb, 0x38, 0xd2, 0x04, 0xd2}, 14, 4, "1234567890.1234"},
+		{[]byte{0x7e, 0xf2, 0x04, 0xc7, 0x2d, 0xfb, 0x2d}, 14, 4, "-1234567890.1234"},
+		// Scale 0 has no decimal point
+		{[]byte{0x80, 0x30, 0x39}, 5, 0, "12345"},
+		{[]byte{0x7f, 0xcf, 0xc6}, 5, 0, "-12345"},
+		{[]byte{0x80, 0x00, 0x00}, 5, 0, "0"},
+		// A full integral group, 2 fractional digits in a byte
+		{[]byte{0x87, 0x5b, 0xcd, 0x15, 0x05}, 11, 2, "123456789.05"},
+		{[]byte{0x80, 0x32}, 4, 2, "0.50"},
+		{[]byte{0x7f, 0xcd}, 4, 2, "-0.50"},
+		{[]byte{0x80, 0x00, 0x00, 0x01}, 9, 9, "0.000000001"},
+		{[]byte{0x80, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02}, 18, 9, "1.000000002"},
+	} {
+		value, err := DecodeDecimal(c.data, c.precision, c.scale)
+		if err != nil {
+			t.Errorf("DECIMAL(%d,%d) %s: %v", c.precision, c.scale, c.want, err)
+		} else if value != c.want {
+			t.Errorf("DECIMAL(%d,%d) decoded %s, want %s", c.precision, c.scale, value, c.want)
+		}
+	}
+
+	for _, c := range []struct {
+		data []byte
+		precision, scale int
+	}{
+		{[]byte{0x80, 0x30}, 5, 0},
+		{[]byte{0x80, 0x30, 0x39, 0x00}, 5, 0},
+		{[]byte{0x80}, 0, 0},
+		{[]byte{0x80}, 2, 3},
+	} {
+		if _, err := DecodeDecimal(c.data, c.precision, c.scale); err == nil {
+			t.Errorf("DECIMAL(%d,%d) % x decoded", c.precision, c.scale, c.data)
+		}
+	}
+}
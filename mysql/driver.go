@@ -32,6 +32,10 @@ func (d *mysqlDriver) Open(dsn string) (driver.Conn, error) {
 		e = errors.New("Incomplete or invalid DSN")
 		return nil, e
 	}
+	mc.cfg.tls, e = parseTLSParam(mc.cfg)
+	if e != nil {
+		return nil, e
+	}
 
 	// Connect to Server
 	mc.netConn, e = net.Dial(mc.cfg.net, mc.cfg.addr)
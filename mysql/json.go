@@ -0,0 +1,746 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Value types of MySQL's binary JSON format
+const (
+	jsonSmallObject byte = 0x00
+	jsonLargeObject byte = 0x01
+	jsonSmallArray  byte = 0x02
+	jsonLargeArray  byte = 0x03
+	jsonLiteral     byte = 0x04
+	jsonInt16       byte = 0x05
+	jsonUint16      byte = 0x06
+	jsonInt32       byte = 0x07
+	jsonUint32      byte = 0x08
+	jsonInt64       byte = 0x09
+	jsonUint64      byte = 0x0a
+	jsonDouble      byte = 0x0b
+	jsonString      byte = 0x0c
+	jsonOpaque      byte = 0x0f
+)
+
+// MySQL refuses documents nested deeper
+const jsonMaxDepth = 100
+
+const (
+	jsonLiteralNull  byte = 0x00
+	jsonLiteralTrue  byte = 0x01
+	jsonLiteralFalse byte = 0x02
+)
+
+// Converts a JSON column value from MySQL's binary format to JSON text,
+// formatted like MySQL does, e.g. {"a": 1, "b": [true, null]}.
+//
+// A binary JSON document is a type byte followed by the value. Objects and
+// arrays are laid out as
+//	element count                    2 bytes (small) or 4 bytes (large)
+//	size in bytes                    2 or 4
+//	key entries (objects only)       key offset (2 or 4), key length (2)
+//	value entries                    type (1), offset or inlined value (2 or 4)
+//	keys and values
+// with offsets counted from the element count.
+func decodeJSON(data []byte) ([]byte, error) {
+	// The server logs an empty value for JSON null in some cases
+	if len(data) == 0 {
+		return []byte("null"), nil
+	}
+	var out bytes.Buffer
+	if e := writeJSONValue(&out, data[0], data[1:], 0); e != nil {
+		return nil, e
+	}
+	return out.Bytes(), nil
+}
+
+func writeJSONValue(out *bytes.Buffer, t byte, data []byte, depth int) error {
+	switch t {
+	case jsonSmallObject, jsonLargeObject, jsonSmallArray, jsonLargeArray:
+		if depth >= jsonMaxDepth {
+			return fmt.Errorf("JSON nested deeper than %d levels", jsonMaxDepth)
+		}
+		return writeJSONContainer(out, t, data, depth + 1)
+
+	case jsonLiteral:
+		if len(data) < 1 {
+			return io.EOF
+		}
+		switch data[0] {
+		case jsonLiteralNull:
+			out.WriteString("null")
+		case jsonLiteralTrue:
+			out.WriteString("true")
+		case jsonLiteralFalse:
+			out.WriteString("false")
+		default:
+			return fmt.Errorf("Invalid JSON literal %d", data[0])
+		}
+
+	case jsonInt16, jsonUint16:
+		if len(data) < 2 {
+			return io.EOF
+		}
+		n := binary.LittleEndian.Uint16(data)
+		if t == jsonInt16 {
+			out.WriteString(strconv.FormatInt(int64(int16(n)), 10))
+		} else {
+			out.WriteString(strconv.FormatUint(uint64(n), 10))
+		}
+
+	case jsonInt32, jsonUint32:
+		if len(data) < 4 {
+			return io.EOF
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if t == jsonInt32 {
+			out.WriteString(strconv.FormatInt(int64(int32(n)), 10))
+		} else {
+			out.WriteString(strconv.FormatUint(uint64(n), 10))
+		}
+
+	case jsonInt64, jsonUint64, jsonDouble:
+		if len(data) < 8 {
+			return io.EOF
+		}
+		n := binary.LittleEndian.Uint64(data)
+		switch t {
+		case jsonInt64:
+			out.WriteString(strconv.FormatInt(int64(n), 10))
+		case jsonUint64:
+			out.WriteString(strconv.FormatUint(n, 10))
+		default:
+			out.WriteString(strconv.FormatFloat(math.Float64frombits(n), 'g', -1, 64))
+		}
+
+	case jsonString:
+		value, e := readJSONVarBytes(data)
+		if e != nil {
+			return e
+		}
+		writeJSONString(out, value)
+
+	case jsonOpaque:
+		if len(data) < 1 {
+			return io.EOF
+		}
+		value, e := readJSONVarBytes(data[1:])
+		if e != nil {
+			return e
+		}
+		return writeJSONOpaque(out, FieldType(data[0]), value)
+
+	default:
+		return fmt.Errorf("Unknown JSON value type %d", t)
+	}
+	return nil
+}
+
+func writeJSONContainer(out *bytes.Buffer, t byte, data []byte, depth int) error {
+	isObject := t == jsonSmallObject || t == jsonLargeObject
+	if isObject {
+		out.WriteByte('{')
+	} else {
+		out.WriteByte('[')
+	}
+	first := true
+	e := walkJSONContainer(t, data, func(key []byte, valueType byte, value []byte) error {
+		if !first {
+			out.WriteString(", ")
+		}
+		first = false
+		if isObject {
+			writeJSONString(out, key)
+			out.WriteString(": ")
+		}
+		return writeJSONValue(out, valueType, value, depth)
+	})
+	if e != nil {
+		return e
+	}
+	if isObject {
+		out.WriteByte('}')
+	} else {
+		out.WriteByte(']')
+	}
+	return nil
+}
+
+// Calls f with the key (nil in arrays), type and data of every element of the
+// container of type t in data, in order. The values stored at offsets have to
+// lie after the entries and must not overlap: siblings sharing a nested
+// container would make its decoding, and output, double with every level.
+func walkJSONContainer(t byte, data []byte, f func(key []byte, valueType byte, value []byte) error) error {
+	isObject := t == jsonSmallObject || t == jsonLargeObject
+	large := t == jsonLargeObject || t == jsonLargeArray
+	offsetSize := 2
+	if large {
+		offsetSize = 4
+	}
+
+	size, e := jsonValueSize(t, data)
+	if e != nil {
+		return e
+	}
+	data = data[:size]
+	count := readJSONOffset(data, offsetSize)
+
+	keyEntrySize := offsetSize + 2
+	valueEntrySize := 1 + offsetSize
+	valueEntries := 2 * offsetSize
+	if isObject {
+		valueEntries += count * keyEntrySize
+	}
+	entriesEnd := valueEntries + count * valueEntrySize
+	if entriesEnd > size {
+		return io.EOF
+	}
+
+	// Where the values stored at offsets are, checked before decoding any
+	type span struct{ start, end int }
+	var spans []span
+	values := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := data[valueEntries + i * valueEntrySize:]
+		valueType := entry[0]
+		if jsonInlined(valueType, large) {
+			values[i] = entry[1 : 1 + offsetSize]
+			continue
+		}
+		offset := readJSONOffset(entry[1:], offsetSize)
+		if offset >= size {
+			return io.EOF
+		}
+		if offset < entriesEnd {
+			// Values follow the entries, an offset into them could loop
+			return fmt.Errorf("Invalid JSON value offset %d", offset)
+		}
+		n, e := jsonValueSize(valueType, data[offset:])
+		if e != nil {
+			return e
+		}
+		values[i] = data[offset : offset + n]
+		spans = append(spans, span{offset, offset + n})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[i - 1].end {
+			return fmt.Errorf("Overlapping JSON values at offsets %d and %d", spans[i - 1].start, spans[i].start)
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		var key []byte
+		if isObject {
+			entry := data[2 * offsetSize + i * keyEntrySize:]
+			keyOffset := readJSONOffset(entry, offsetSize)
+			keyLength := int(binary.LittleEndian.Uint16(entry[offsetSize:]))
+			if keyOffset + keyLength > size {
+				return io.EOF
+			}
+			key = data[keyOffset : keyOffset + keyLength]
+		}
+		entry := data[valueEntries + i * valueEntrySize:]
+		if e := f(key, entry[0], values[i]); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Returns the number of bytes the value of type t at the start of data takes
+// up, without decoding it
+func jsonValueSize(t byte, data []byte) (int, error) {
+	n := 0
+	switch t {
+	case jsonSmallObject, jsonSmallArray, jsonLargeObject, jsonLargeArray:
+		offsetSize := 2
+		if t == jsonLargeObject || t == jsonLargeArray {
+			offsetSize = 4
+		}
+		if len(data) < 2 * offsetSize {
+			return 0, io.EOF
+		}
+		n = readJSONOffset(data[offsetSize:], offsetSize)
+	case jsonLiteral:
+		n = 1
+	case jsonInt16, jsonUint16:
+		n = 2
+	case jsonInt32, jsonUint32:
+		n = 4
+	case jsonInt64, jsonUint64, jsonDouble:
+		n = 8
+	case jsonString, jsonOpaque:
+		header := 0
+		if t == jsonOpaque {
+			header = 1
+		}
+		if len(data) < header {
+			return 0, io.EOF
+		}
+		length, lengthSize, e := readJSONVarLength(data[header:])
+		if e != nil {
+			return 0, e
+		}
+		n = header + lengthSize + length
+	default:
+		return 0, fmt.Errorf("Unknown JSON value type %d", t)
+	}
+	if n > len(data) {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Small values are stored in the value entry instead of at an offset
+func jsonInlined(t byte, large bool) bool {
+	switch t {
+	case jsonLiteral, jsonInt16, jsonUint16:
+		return true
+	case jsonInt32, jsonUint32:
+		return large
+	}
+	return false
+}
+
+func readJSONOffset(data []byte, offsetSize int) int {
+	if offsetSize == 2 {
+		return int(binary.LittleEndian.Uint16(data))
+	}
+	return int(binary.LittleEndian.Uint32(data))
+}
+
+// Reads bytes prefixed by their length, see readJSONVarLength
+func readJSONVarBytes(data []byte) ([]byte, error) {
+	length, n, e := readJSONVarLength(data)
+	if e != nil {
+		return nil, e
+	}
+	data = data[n:]
+	if length > len(data) {
+		return nil, io.EOF
+	}
+	return data[:length], nil
+}
+
+// Reads a length stored 7 bits per byte with the high bit set on all bytes but
+// the last, and returns it with the number of bytes it took
+func readJSONVarLength(data []byte) (length, n int, e error) {
+	for i := 0; i < 5 && i < len(data); i++ {
+		length |= int(data[i] & 0x7f) << (7 * uint(i))
+		if data[i] & 0x80 == 0 {
+			return length, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("Invalid JSON variable length")
+}
+
+func writeJSONString(out *bytes.Buffer, s []byte) {
+	out.WriteByte('"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			out.WriteByte('\\')
+			out.WriteByte(c)
+		case c == '\n':
+			out.WriteString(`\n`)
+		case c == '\r':
+			out.WriteString(`\r`)
+		case c == '\t':
+			out.WriteString(`\t`)
+		case c == '\b':
+			out.WriteString(`\b`)
+		case c == '\f':
+			out.WriteString(`\f`)
+		case c < 0x20:
+			fmt.Fprintf(out, `\u%04x`, c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	out.WriteByte('"')
+}
+
+// Opaque values hold MySQL types JSON has no type for, e.g. from
+// CAST(NOW() AS JSON).
+func writeJSONOpaque(out *bytes.Buffer, t FieldType, data []byte) error {
+	switch t {
+	case FIELD_TYPE_NEWDECIMAL:
+		if len(data) < 2 {
+			return io.EOF
+		}
+		value, e := DecodeDecimal(data[2:], int(data[0]), int(data[1]))
+		if e != nil {
+			return e
+		}
+		out.WriteString(value)
+
+	case FIELD_TYPE_DATE, FIELD_TYPE_DATETIME, FIELD_TYPE_TIMESTAMP, FIELD_TYPE_TIME:
+		if len(data) < 8 {
+			return io.EOF
+		}
+		out.WriteByte('"')
+		out.WriteString(formatPackedTemporal(t, int64(binary.LittleEndian.Uint64(data))))
+		out.WriteByte('"')
+
+	default:
+		fmt.Fprintf(out, `"base64:type%d:%s"`, t, base64.StdEncoding.EncodeToString(data))
+	}
+	return nil
+}
+
+// Formats a temporal value packed into an integer as MySQL does in memory:
+// the date and time fields bit-packed above 24 bits of microseconds.
+func formatPackedTemporal(t FieldType, packed int64) string {
+	sign := ""
+	if packed < 0 {
+		sign = "-"
+		packed = -packed
+	}
+	usec := packed % (1 << 24)
+	intPart := packed >> 24
+
+	if t == FIELD_TYPE_TIME {
+		hour := (intPart >> 12) % (1 << 10)
+		minute := (intPart >> 6) % (1 << 6)
+		second := intPart % (1 << 6)
+		return fmt.Sprintf("%s%02d:%02d:%02d.%06d", sign, hour, minute, second, usec)
+	}
+
+	ymd := intPart >> 17
+	ym := ymd >> 5
+	hms := intPart % (1 << 17)
+	date := fmt.Sprintf("%04d-%02d-%02d", ym / 13, ym % 13, ymd % (1 << 5))
+	if t == FIELD_TYPE_DATE {
+		return date
+	}
+	return fmt.Sprintf("%s %02d:%02d:%02d.%06d", date, hms >> 12, (hms >> 6) % (1 << 6), hms % (1 << 6), usec)
+}
+
+// The operations of a partial JSON update, numbered as MySQL logs them
+type JSONDiffOperation uint8
+
+const (
+	JSON_DIFF_REPLACE JSONDiffOperation = iota
+	JSON_DIFF_INSERT
+	JSON_DIFF_REMOVE
+)
+
+func (op JSONDiffOperation) String() string {
+	switch op {
+	case JSON_DIFF_REPLACE:
+		return "REPLACE"
+	case JSON_DIFF_INSERT:
+		return "INSERT"
+	case JSON_DIFF_REMOVE:
+		return "REMOVE"
+	}
+	return "UNKNOWN"
+}
+
+// JSONDiff is a change logged by a partial JSON update, e.g. from JSON_SET():
+// the operation, the path it applies to, and the JSON text of the new value,
+// empty for JSON_DIFF_REMOVE.
+type JSONDiff struct {
+	Operation JSONDiffOperation
+	Path string
+	Value string
+}
+
+// A diff as logged, with its value in binary JSON
+type jsonDiff struct {
+	op JSONDiffOperation
+	path []byte
+	value []byte
+}
+
+/* Layout of a JSON column logged as diffs, repeated until the column's end
+ * operation                       1 byte
+ * path length                     length encoded integer
+ * path                            string
+ * value length (not for REMOVE)   length encoded integer
+ * value (not for REMOVE)          type byte and binary JSON value
+ */
+func parseJSONDiffs(data []byte) ([]jsonDiff, error) {
+	buf := bytes.NewBuffer(data)
+	var diffs []jsonDiff
+	for buf.Len() > 0 {
+		var diff jsonDiff
+		op, _ := buf.ReadByte()
+		diff.op = JSONDiffOperation(op)
+		if diff.op > JSON_DIFF_REMOVE {
+			return nil, fmt.Errorf("Invalid JSON diff operation %d", op)
+		}
+		length, _, e := readLengthEncodedInt(buf)
+		if e != nil {
+			return nil, e
+		}
+		if diff.path, e = readBytes(buf, int(length)); e != nil {
+			return nil, e
+		}
+		if diff.op != JSON_DIFF_REMOVE {
+			if length, _, e = readLengthEncodedInt(buf); e != nil {
+				return nil, e
+			}
+			if diff.value, e = readBytes(buf, int(length)); e != nil {
+				return nil, e
+			}
+			if len(diff.value) == 0 {
+				return nil, fmt.Errorf("Empty JSON diff value for %s", diff.path)
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// Converts the diffs to JSONDiff, for when there is no document to apply
+// them to
+func decodeJSONDiffs(diffs []jsonDiff) ([]JSONDiff, error) {
+	decoded := make([]JSONDiff, len(diffs))
+	for i, diff := range diffs {
+		decoded[i] = JSONDiff{Operation: diff.op, Path: string(diff.path)}
+		if diff.op != JSON_DIFF_REMOVE {
+			value, e := decodeJSON(diff.value)
+			if e != nil {
+				return nil, e
+			}
+			decoded[i].Value = string(value)
+		}
+	}
+	return decoded, nil
+}
+
+// Applies the diffs to the binary JSON document doc, in order, and returns
+// the resulting document as JSON text, formatted like decodeJSON does
+func applyJSONDiffs(doc []byte, diffs []jsonDiff) ([]byte, error) {
+	var root *jsonNode
+	var e error
+	if len(doc) == 0 {
+		root = &jsonNode{text: []byte("null")}
+	} else if root, e = newJSONNode(doc[0], doc[1:], 0); e != nil {
+		return nil, e
+	}
+
+	for _, diff := range diffs {
+		steps, e := parseJSONPath(diff.path)
+		if e != nil {
+			return nil, e
+		}
+		var value *jsonNode
+		if diff.op != JSON_DIFF_REMOVE {
+			if value, e = newJSONNode(diff.value[0], diff.value[1:], len(steps)); e != nil {
+				return nil, e
+			}
+		}
+		if len(steps) == 0 {
+			if diff.op != JSON_DIFF_REPLACE {
+				return nil, fmt.Errorf("Invalid JSON diff: %s of the whole document", diff.op)
+			}
+			root = value
+			continue
+		}
+
+		parent := root
+		for _, step := range steps[:len(steps) - 1] {
+			i, found := parent.lookup(step)
+			if !found {
+				return nil, fmt.Errorf("JSON diff path %s not found", diff.path)
+			}
+			parent = parent.elements[i]
+		}
+		if e = parent.apply(diff.op, steps[len(steps) - 1], value); e != nil {
+			return nil, fmt.Errorf("JSON diff %s at %s: %v", diff.op, diff.path, e)
+		}
+	}
+
+	var out bytes.Buffer
+	root.writeTo(&out)
+	return out.Bytes(), nil
+}
+
+// A JSON document being edited: scalars hold their JSON text, objects and
+// arrays their elements (and keys)
+type jsonNode struct {
+	text []byte
+	isObject bool
+	keys [][]byte
+	elements []*jsonNode
+}
+
+func newJSONNode(t byte, data []byte, depth int) (*jsonNode, error) {
+	switch t {
+	case jsonSmallObject, jsonLargeObject, jsonSmallArray, jsonLargeArray:
+		if depth >= jsonMaxDepth {
+			return nil, fmt.Errorf("JSON nested deeper than %d levels", jsonMaxDepth)
+		}
+		node := &jsonNode{isObject: t == jsonSmallObject || t == jsonLargeObject, elements: []*jsonNode{}}
+		e := walkJSONContainer(t, data, func(key []byte, valueType byte, value []byte) error {
+			element, e := newJSONNode(valueType, value, depth + 1)
+			if e != nil {
+				return e
+			}
+			if node.isObject {
+				node.keys = append(node.keys, key)
+			}
+			node.elements = append(node.elements, element)
+			return nil
+		})
+		if e != nil {
+			return nil, e
+		}
+		return node, nil
+	}
+	var out bytes.Buffer
+	if e := writeJSONValue(&out, t, data, depth); e != nil {
+		return nil, e
+	}
+	return &jsonNode{text: out.Bytes()}, nil
+}
+
+// Returns the index of the element the step leads to, if there is one
+func (node *jsonNode) lookup(step jsonPathStep) (int, bool) {
+	if node.text != nil || node.isObject != (step.key != nil) {
+		return 0, false
+	}
+	if node.isObject {
+		for i, key := range node.keys {
+			if bytes.Equal(key, step.key) {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	return step.index, step.index < len(node.elements)
+}
+
+// Applies op to the element of node the step leads to, like MySQL does:
+// REPLACE and REMOVE need the element to exist, INSERT adds a member to an
+// object (or replaces it) or inserts into an array, at its end if the index
+// is past it.
+func (node *jsonNode) apply(op JSONDiffOperation, step jsonPathStep, value *jsonNode) error {
+	i, found := node.lookup(step)
+	switch {
+	case found && op == JSON_DIFF_REMOVE:
+		node.elements = append(node.elements[:i], node.elements[i + 1:]...)
+		if node.isObject {
+			node.keys = append(node.keys[:i], node.keys[i + 1:]...)
+		}
+	case found && (op == JSON_DIFF_REPLACE || node.isObject):
+		node.elements[i] = value
+	case op == JSON_DIFF_INSERT && node.text == nil && node.isObject == (step.key != nil):
+		if node.isObject {
+			// MySQL keeps object members sorted by key length, then key
+			i = sort.Search(len(node.keys), func(j int) bool {
+				key := node.keys[j]
+				return len(key) > len(step.key) || len(key) == len(step.key) && bytes.Compare(key, step.key) > 0
+			})
+			node.keys = append(node.keys[:i], append([][]byte{step.key}, node.keys[i:]...)...)
+		} else if i = step.index; i > len(node.elements) {
+			i = len(node.elements)
+		}
+		node.elements = append(node.elements[:i], append([]*jsonNode{value}, node.elements[i:]...)...)
+	default:
+		return errors.New("Path not found")
+	}
+	return nil
+}
+
+func (node *jsonNode) writeTo(out *bytes.Buffer) {
+	if node.text != nil {
+		out.Write(node.text)
+		return
+	}
+	if node.isObject {
+		out.WriteByte('{')
+	} else {
+		out.WriteByte('[')
+	}
+	for i, element := range node.elements {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		if node.isObject {
+			writeJSONString(out, node.keys[i])
+			out.WriteString(": ")
+		}
+		element.writeTo(out)
+	}
+	if node.isObject {
+		out.WriteByte('}')
+	} else {
+		out.WriteByte(']')
+	}
+}
+
+// A step of a JSON path: an object member, or an array element if key is nil
+type jsonPathStep struct {
+	key []byte
+	index int
+}
+
+// Parses the paths of JSON diffs, e.g. $.a."b c"[2]: the document root $,
+// followed by members and array elements, without wildcards.
+func parseJSONPath(path []byte) ([]jsonPathStep, error) {
+	invalid := fmt.Errorf("Invalid JSON path %q", path)
+	if len(path) == 0 || path[0] != '$' {
+		return nil, invalid
+	}
+	var steps []jsonPathStep
+	for rest := path[1:]; len(rest) > 0; {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			var key []byte
+			if len(rest) > 0 && rest[0] == '"' {
+				end := 1
+				for end < len(rest) && rest[end] != '"' {
+					if rest[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(rest) {
+					return nil, invalid
+				}
+				unquoted, e := strconv.Unquote(string(rest[:end + 1]))
+				if e != nil {
+					return nil, invalid
+				}
+				key, rest = []byte(unquoted), rest[end + 1:]
+			} else {
+				end := bytes.IndexAny(rest, ".[")
+				if end < 0 {
+					end = len(rest)
+				}
+				if end == 0 {
+					return nil, invalid
+				}
+				key, rest = rest[:end], rest[end:]
+			}
+			steps = append(steps, jsonPathStep{key: key})
+		case '[':
+			end := bytes.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, invalid
+			}
+			index, e := strconv.ParseUint(string(rest[1:end]), 10, 31)
+			if e != nil {
+				return nil, invalid
+			}
+			steps = append(steps, jsonPathStep{index: int(index)})
+			rest = rest[end + 1:]
+		default:
+			return nil, invalid
+		}
+	}
+	return steps, nil
+}
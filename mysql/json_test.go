@@ -0,0 +1,193 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// An object of a test document, whose members are encoded in order
+type testJSONObject []testJSONMember
+
+type testJSONMember struct {
+	key string
+	value interface{}
+}
+
+// Encodes a test document in MySQL's binary JSON format, with small
+// containers: int is an inlined INT16, string a STRING, []interface{} an array
+// and testJSONObject an object
+func makeTestJSON(v interface{}) []byte {
+	t, data := encodeTestJSON(v)
+	return append([]byte{t}, data...)
+}
+
+func encodeTestJSON(v interface{}) (byte, []byte) {
+	switch v := v.(type) {
+	case int:
+		return jsonInt16, binary.LittleEndian.AppendUint16(nil, uint16(int16(v)))
+	case string:
+		return jsonString, append([]byte{byte(len(v))}, v...)
+	case []interface{}:
+		return jsonSmallArray, encodeTestJSONContainer(nil, v)
+	case testJSONObject:
+		keys := []string{}
+		values := []interface{}{}
+		for _, member := range v {
+			keys = append(keys, member.key)
+			values = append(values, member.value)
+		}
+		return jsonSmallObject, encodeTestJSONContainer(keys, values)
+	}
+	panic("unknown test JSON value")
+}
+
+// Lays out the elements of a small container, an object if keys isn't nil
+func encodeTestJSONContainer(keys []string, values []interface{}) []byte {
+	entriesSize := 4 + 3 * len(values)
+	if keys != nil {
+		entriesSize += 4 * len(keys)
+	}
+	var keyEntries, keyData []byte
+	for _, key := range keys {
+		keyEntries = binary.LittleEndian.AppendUint16(keyEntries, uint16(entriesSize + len(keyData)))
+		keyEntries = binary.LittleEndian.AppendUint16(keyEntries, uint16(len(key)))
+		keyData = append(keyData, key...)
+	}
+	valuesStart := entriesSize + len(keyData)
+	var valueEntries, valueData []byte
+	for _, value := range values {
+		t, data := encodeTestJSON(value)
+		valueEntries = append(valueEntries, t)
+		if t == jsonInt16 {
+			valueEntries = append(valueEntries, data...)
+			continue
+		}
+		valueEntries = binary.LittleEndian.AppendUint16(valueEntries, uint16(valuesStart + len(valueData)))
+		valueData = append(valueData, data...)
+	}
+	data := binary.LittleEndian.AppendUint16(nil, uint16(len(values)))
+	data = binary.LittleEndian.AppendUint16(data, uint16(valuesStart + len(valueData)))
+	data = append(data, keyEntries...)
+	data = append(data, valueEntries...)
+	data = append(data, keyData...)
+	return append(data, valueData...)
+}
+
+// Returns a document of arrays nested depth levels deep, each holding its
+// nested array twice at the same offset
+func makeSharedOffsetsJSON(depth int) []byte {
+	data := []byte{0, 0, 4, 0}
+	for i := 0; i < depth; i++ {
+		size := 10 + len(data)
+		nested := data
+		data = []byte{2, 0, byte(size), byte(size >> 8), jsonSmallArray, 10, 0, jsonSmallArray, 10, 0}
+		data = append(data, nested...)
+	}
+	return append([]byte{jsonSmallArray}, data...)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	for _, c := range []struct {
+		document interface{}
+		want string
+	}{
+		{testJSONObject{{"a", 1}}, `{"a": 1}`},
+		{testJSONObject{}, `{}`},
+		{[]interface{}{1, "x", []interface{}{}, testJSONObject{{"b", -2}}}, `[1, "x", [], {"b": -2}]`},
+		{testJSONObject{{"a", testJSONObject{{"b", 1}}}, {"arr", []interface{}{1, 2}}}, `{"a": {"b": 1}, "arr": [1, 2]}`},
+		{"quote\"", `"quote\""`},
+	} {
+		decoded, err := decodeJSON(makeTestJSON(c.document))
+		if err != nil {
+			t.Errorf("%s: %v", c.want, err)
+		} else if string(decoded) != c.want {
+			t.Errorf("decoded %s, want %s", decoded, c.want)
+		}
+	}
+}
+
+func TestDecodeJSONSharedOffsets(t *testing.T) {
+	// Decodes to [[], []] but siblings can't share their value
+	if _, err := decodeJSON(makeSharedOffsetsJSON(1)); err == nil {
+		t.Error("arrays sharing an offset decoded")
+	}
+	// Would decode to 2^22 empty arrays, 25 MB, from 225 bytes
+	if _, err := decodeJSON(makeSharedOffsetsJSON(22)); err == nil {
+		t.Error("arrays sharing offsets 22 levels deep decoded")
+	}
+
+	// The second element starts inside the first one
+	document := makeTestJSON([]interface{}{[]interface{}{"ab"}, "c"})
+	binary.LittleEndian.PutUint16(document[1 + 8:], binary.LittleEndian.Uint16(document[1 + 8:]) - 1)
+	if _, err := decodeJSON(document); err == nil {
+		t.Error("overlapping values decoded")
+	}
+}
+
+// Encodes a diff of a partial JSON update, value is left out if nil
+func makeTestJSONDiff(op JSONDiffOperation, path string, value interface{}) []byte {
+	diff := append([]byte{byte(op), byte(len(path))}, path...)
+	if value != nil {
+		encoded := makeTestJSON(value)
+		diff = append(diff, byte(len(encoded)))
+		diff = append(diff, encoded...)
+	}
+	return diff
+}
+
+func TestApplyJSONDiffs(t *testing.T) {
+	document := testJSONObject{{"a", testJSONObject{{"b", 1}, {"c", "x"}}}, {"arr", []interface{}{1, 2}}}
+	for _, c := range []struct {
+		diffs [][]byte
+		want string
+	}{
+		{[][]byte{makeTestJSONDiff(JSON_DIFF_REPLACE, "$.a.b", "new")}, `{"a": {"b": "new", "c": "x"}, "arr": [1, 2]}`},
+		{[][]byte{makeTestJSONDiff(JSON_DIFF_INSERT, "$.arr[1]", 5)}, `{"a": {"b": 1, "c": "x"}, "arr": [1, 5, 2]}`},
+		{[][]byte{makeTestJSONDiff(JSON_DIFF_INSERT, "$.arr[9]", 5)}, `{"a": {"b": 1, "c": "x"}, "arr": [1, 2, 5]}`},
+		{[][]byte{makeTestJSONDiff(JSON_DIFF_REMOVE, "$.a.c", nil)}, `{"a": {"b": 1}, "arr": [1, 2]}`},
+		{[][]byte{makeTestJSONDiff(JSON_DIFF_REMOVE, "$.arr[0]", nil)}, `{"a": {"b": 1, "c": "x"}, "arr": [2]}`},
+		// Members are kept sorted by key length, then key
+		{[][]byte{makeTestJSONDiff(JSON_DIFF_INSERT, `$."ab"`, 3)}, `{"a": {"b": 1, "c": "x"}, "ab": 3, "arr": [1, 2]}`},
+		{[][]byte{makeTestJSONDiff(JSON_DIFF_REPLACE, "$", []interface{}{})}, `[]`},
+		// In order, each on the result of the previous ones
+		{[][]byte{
+			makeTestJSONDiff(JSON_DIFF_REMOVE, "$.a", nil),
+			makeTestJSONDiff(JSON_DIFF_INSERT, "$.a", testJSONObject{}),
+			makeTestJSONDiff(JSON_DIFF_INSERT, "$.a.z", 7),
+		}, `{"a": {"z": 7}, "arr": [1, 2]}`},
+	} {
+		var data []byte
+		for _, diff := range c.diffs {
+			data = append(data, diff...)
+		}
+		diffs, err := parseJSONDiffs(data)
+		if err != nil {
+			t.Errorf("%s: %v", c.want, err)
+			continue
+		}
+		applied, err := applyJSONDiffs(makeTestJSON(document), diffs)
+		if err != nil {
+			t.Errorf("%s: %v", c.want, err)
+		} else if string(applied) != c.want {
+			t.Errorf("applied %s, want %s", applied, c.want)
+		}
+	}
+
+	for _, diff := range [][]byte{
+		makeTestJSONDiff(JSON_DIFF_REPLACE, "$.missing", 1),
+		makeTestJSONDiff(JSON_DIFF_REMOVE, "$.arr[2]", nil),
+		makeTestJSONDiff(JSON_DIFF_INSERT, "$.x.y", 1),
+		makeTestJSONDiff(JSON_DIFF_INSERT, "$.a[0]", 1),
+		makeTestJSONDiff(JSON_DIFF_REMOVE, "$", nil),
+		makeTestJSONDiff(JSON_DIFF_REPLACE, "a.b", 1),
+		{3, 1, '$'},
+	} {
+		diffs, err := parseJSONDiffs(diff)
+		if err == nil {
+			_, err = applyJSONDiffs(makeTestJSON(document), diffs)
+		}
+		if err == nil {
+			t.Errorf("diff %q applied", diff)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Flavor is the kind of server a binlog comes from. MySQL and MariaDB share
+// the binlog format, but each logs event types of its own, e.g. for GTIDs.
+type Flavor uint8
+
+const (
+	FLAVOR_MYSQL Flavor = iota
+	FLAVOR_MARIADB
+)
+
+func (flavor Flavor) String() string {
+	switch flavor {
+	case FLAVOR_MYSQL:
+		return "MySQL"
+	case FLAVOR_MARIADB:
+		return "MariaDB"
+	}
+	return fmt.Sprintf("%d", uint8(flavor))
+}
+
+// Tells the flavor of a server from its version, e.g. "10.6.12-MariaDB-log"
+func flavorOfVersion(version string) (Flavor) {
+	if strings.Contains(version, "MariaDB") {
+		return FLAVOR_MARIADB
+	}
+	return FLAVOR_MYSQL
+}
+
+// Capability a MariaDB replica announces with @mariadb_slave_capability to be
+// sent GTID events. Masters replace them with "BEGIN" QUERY_EVENTs otherwise,
+// and GTID_LIST events with dummy ones.
+const MARIA_SLAVE_CAPABILITY_GTID = 4
+
+
+// MariaDB GTIDs are made of a replication domain, the server id of the
+// master that logged the transaction first and a sequence number, written
+// domain-server-sequence, e.g. "0-1-100"
+type MariaDBGTID struct {
+	DomainId uint32
+	ServerId uint32
+	SequenceNumber uint64
+}
+
+func (gtid MariaDBGTID) String() string {
+	return fmt.Sprintf("%d-%d-%d", gtid.DomainId, gtid.ServerId, gtid.SequenceNumber)
+}
+
+// Flags of a MARIADB_GTID_EVENT
+const (
+	// The event group is a single statement without BEGIN and COMMIT, e.g. DDL
+	MARIADB_FL_STANDALONE uint8 = 1 << iota
+	MARIADB_FL_GROUP_COMMIT_ID
+	MARIADB_FL_TRANSACTIONAL
+	MARIADB_FL_ALLOW_PARALLEL
+	MARIADB_FL_WAITED
+	MARIADB_FL_DDL
+	MARIADB_FL_PREPARED_XA
+	MARIADB_FL_COMPLETED_XA
+)
+
+// Starts every event group of MariaDB 10.0+, in place of the "BEGIN"
+// QUERY_EVENT of transactions
+type MariaDBGTIDEvent struct {
+	header EventHeader
+	gtid MariaDBGTID
+	flags uint8
+	commitId uint64
+}
+
+/* MariaDB GTID Event
+Bytes                        Name
+-----                        ----
+8                            sequence number
+4                            domain id
+1                            flags
+  if flags & MARIADB_FL_GROUP_COMMIT_ID:
+8                            commit id
+  else:
+6                            padding
+*/
+func parseMariaDBGTIDEvent(buf *bytes.Buffer) (event *MariaDBGTIDEvent, err error) {
+	event = new(MariaDBGTIDEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	event.gtid.ServerId = event.header.ServerId
+	if err = binary.Read(buf, binary.LittleEndian, &event.gtid.SequenceNumber); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &event.gtid.DomainId); err != nil {
+		return
+	}
+	if event.flags, err = buf.ReadByte(); err != nil {
+		return
+	}
+	if event.flags & MARIADB_FL_GROUP_COMMIT_ID != 0 {
+		err = binary.Read(buf, binary.LittleEndian, &event.commitId)
+	}
+	// The XID of XA transactions that may follow is left out
+	return
+}
+
+func (event *MariaDBGTIDEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+// GTID returns the global transaction identifier of the event group
+func (event *MariaDBGTIDEvent) GTID() (MariaDBGTID) {
+	return event.gtid
+}
+
+// Flags returns the MARIADB_FL_* flags of the event group
+func (event *MariaDBGTIDEvent) Flags() (uint8) {
+	return event.flags
+}
+
+// Standalone tells whether the event group is a single statement, which has
+// no XID_EVENT or "COMMIT" QUERY_EVENT ending it
+func (event *MariaDBGTIDEvent) Standalone() (bool) {
+	return event.flags & MARIADB_FL_STANDALONE != 0
+}
+
+// CommitId returns the id shared by the transactions committed together in a
+// group commit, which can be applied in parallel, or 0 if the master didn't
+// log it
+func (event *MariaDBGTIDEvent) CommitId() (uint64) {
+	return event.commitId
+}
+
+func (event *MariaDBGTIDEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *MariaDBGTIDEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "gtid: %s, flags: %v, commitId: %v\n", event.gtid, event.flags, event.commitId)
+}
+
+func (event *MariaDBGTIDEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"gtid": event.gtid.String(),
+		"domainId": event.gtid.DomainId,
+		"sequenceNumber": event.gtid.SequenceNumber,
+		"flags": event.flags,
+		"commitId": event.commitId,
+	})
+}
+
+
+// Logged at the start of every binlog file of MariaDB 10.0+, with the last
+// GTID of every replication domain and server in the files before it
+type MariaDBGTIDListEvent struct {
+	header EventHeader
+	flags uint8
+	gtids []MariaDBGTID
+}
+
+/* MariaDB GTID List Event
+Bytes                        Name
+-----                        ----
+4                            number of GTIDs (low 28 bits), flags (high 4 bits)
+  for each GTID:
+4                            domain id
+4                            server id
+8                            sequence number
+*/
+func parseMariaDBGTIDListEvent(buf *bytes.Buffer) (event *MariaDBGTIDListEvent, err error) {
+	event = new(MariaDBGTIDListEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+	var countAndFlags uint32
+	if err = binary.Read(buf, binary.LittleEndian, &countAndFlags); err != nil {
+		return
+	}
+	event.flags = uint8(countAndFlags >> 28)
+	count := int(countAndFlags & (1 << 28 - 1))
+	// Don't let a corrupt count allocate more than the event holds
+	if count > buf.Len() / 16 {
+		return nil, &ErrTruncatedEvent{ReadSize: count * 16, Remaining: buf.Len()}
+	}
+	event.gtids = make([]MariaDBGTID, count)
+	for i := range event.gtids {
+		if err = binary.Read(buf, binary.LittleEndian, &event.gtids[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (event *MariaDBGTIDListEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+// GTIDs returns the last GTID logged for every domain and server before the
+// binlog file this event starts
+func (event *MariaDBGTIDListEvent) GTIDs() ([]MariaDBGTID) {
+	return event.gtids
+}
+
+func (event *MariaDBGTIDListEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *MariaDBGTIDListEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "flags: %v, gtids: %s\n", event.flags, event.gtidList())
+}
+
+func (event *MariaDBGTIDListEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"flags": event.flags,
+		"gtids": event.gtidList(),
+	})
+}
+
+// Returns the GTIDs in the format of @@gtid_binlog_pos, e.g. "0-1-100,1-2-5"
+func (event *MariaDBGTIDListEvent) gtidList() string {
+	gtids := make([]string, len(event.gtids))
+	for i, gtid := range event.gtids {
+		gtids[i] = gtid.String()
+	}
+	return strings.Join(gtids, ",")
+}
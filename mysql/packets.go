@@ -9,6 +9,8 @@
 package mysql
 
 import (
+	"bufio"
+	"crypto/tls"
 	"database/sql/driver"
 	"errors"
 	"fmt"
@@ -19,46 +21,51 @@ import (
 // Packets documentation:
 // http://forge.mysql.com/wiki/MySQL_Internals_ClientServer_Protocol
 
-// Read packet to buffer 'data'
+// Read packet to buffer 'data'. Payloads of MAX_PACKET_SIZE bytes or more
+// are sent as several packets, all but the last MAX_PACKET_SIZE bytes long (the
+// last one may be empty), and are returned reassembled.
 func (mc *mysqlConn) readPacket() ([]byte, error) {
-	// Packet Length
-	pktLen, e := mc.readNumber(3)
-	if e != nil {
-		return nil, e
-	}
-
-	if int(pktLen) == 0 {
-		return nil, e
-	}
+	var data []byte
+	for {
+		// Packet Length
+		pktLen, e := mc.readNumber(3)
+		if e != nil {
+			return nil, e
+		}
 
-	// Packet Number
-	pktSeq, e := mc.readNumber(1)
-	if e != nil {
-		return nil, e
-	}
+		// Packet Number
+		pktSeq, e := mc.readNumber(1)
+		if e != nil {
+			return nil, e
+		}
 
-	// Check Packet Sync
-	if uint8(pktSeq) != mc.sequence {
-		e = errors.New("Commands out of sync; you can't run this command now")
-		return nil, e
-	}
-	mc.sequence++
+		// Check Packet Sync
+		if uint8(pktSeq) != mc.sequence {
+			e = errors.New("Commands out of sync; you can't run this command now")
+			return nil, e
+		}
+		mc.sequence++
+
+		// Read rest of packet
+		start := len(data)
+		data = append(data, make([]byte, pktLen)...)
+		var n, add int
+		for e == nil && n < int(pktLen) {
+			add, e = mc.bufReader.Read(data[start + n:])
+			n += add
+		}
+		if e != nil || n < int(pktLen) {
+			if e == nil {
+				e = fmt.Errorf("Length of read data (%d) does not match body length (%d)", n, pktLen)
+			}
+			mc.log().Infof("Reading packet: %v", e)
+			return nil, driver.ErrBadConn
+		}
 
-	// Read rest of packet
-	data := make([]byte, pktLen)
-	var n, add int
-	for e == nil && n < int(pktLen) {
-		add, e = mc.bufReader.Read(data[n:])
-		n += add
-	}
-	if e != nil || n < int(pktLen) {
-		if e == nil {
-			e = fmt.Errorf("Length of read data (%d) does not match body length (%d)", n, pktLen)
+		if pktLen < MAX_PACKET_SIZE {
+			return data, nil
 		}
-		errLog.Print(`packets:58 `, e)
-		return nil, driver.ErrBadConn
 	}
-	return data, e
 }
 
 // Read n bytes long number num
@@ -75,7 +82,7 @@ func (mc *mysqlConn) readNumber(nr uint8) (uint64, error) {
 		if e == nil {
 			e = fmt.Errorf("Length of read data (%d) does not match header length (%d)", n, nr)
 		}
-		errLog.Print(`packets:78 `, e)
+		mc.log().Infof("Reading packet: %v", e)
 		return 0, driver.ErrBadConn
 	}
 
@@ -99,7 +106,7 @@ func (mc *mysqlConn) writePacket(data *[]byte) error {
 		if e == nil {
 			e = errors.New("Length of send data does not match packet length")
 		}
-		errLog.Print(`packets:102 `, e)
+		mc.log().Infof("Writing packet: %v", e)
 		return driver.ErrBadConn
 	}
 
@@ -211,6 +218,16 @@ func (mc *mysqlConn) writeAuthPacket() (e error) {
 	if len(mc.cfg.dbname) > 0 {
 		clientFlags |= uint32(CLIENT_CONNECT_WITH_DB)
 	}
+	// Switch to TLS before sending the credentials
+	if mc.cfg.tls != nil {
+		if mc.server.flags&CLIENT_SSL == 0 {
+			return errors.New("TLS requested but the MySQL-Server does not support it")
+		}
+		clientFlags |= uint32(CLIENT_SSL)
+		if e = mc.writeSSLRequestPacket(clientFlags); e != nil {
+			return
+		}
+	}
 
 	// User Password
 	scrambleBuff := scramblePassword(mc.server.scrambleBuff, []byte(mc.cfg.passwd))
@@ -260,6 +277,45 @@ func (mc *mysqlConn) writeAuthPacket() (e error) {
 	return mc.writePacket(&data)
 }
 
+/* SSL Request Packet
+Bytes                        Name
+-----                        ----
+4                            client_flags (with CLIENT_SSL)
+4                            max_packet_size
+1                            charset_number
+23                           (filler) always 0x00...
+Sent before the Client Authentication Packet, which then follows over TLS.
+*/
+func (mc *mysqlConn) writeSSLRequestPacket(clientFlags uint32) (e error) {
+	pktLen := 4 + 4 + 1 + 23
+	data := make([]byte, 0, pktLen+4)
+
+	// Add the packet header
+	data = append(data, uint24ToBytes(uint32(pktLen))...)
+	data = append(data, mc.sequence)
+
+	data = append(data, uint32ToBytes(clientFlags)...)
+	data = append(data, uint32ToBytes(MAX_PACKET_SIZE)...)
+	data = append(data, mc.server.charset)
+	data = append(data, make([]byte, 23)...)
+
+	e = mc.writePacket(&data)
+	if e != nil {
+		return
+	}
+
+	// The server sends nothing more before the TLS handshake, so there is no
+	// buffered data to carry over
+	tlsConn := tls.Client(mc.netConn, mc.cfg.tls)
+	e = tlsConn.Handshake()
+	if e != nil {
+		return
+	}
+	mc.netConn = tlsConn
+	mc.bufReader = bufio.NewReader(tlsConn)
+	return
+}
+
 /******************************************************************************
 *                             Command Packets                                 *
 ******************************************************************************/
@@ -308,6 +364,23 @@ func (mc *mysqlConn) writeCommandPacket(command commandType, args ...interface{}
 		arg = append(arg, uint32ToBytes(args[2].(uint32))...)
 		arg = append(arg, []byte(args[3].(string))...)
 
+	case COM_BINLOG_DUMP_GTID:
+		if len(args) != 5 {
+			return fmt.Errorf("Invalid arguments count (Got: %d Has: 5)", len(args))
+		}
+		flags := args[0].(uint16)
+		filename := args[2].(string)
+		data := args[4].([]byte)
+		arg = uint16ToBytes(flags)
+		arg = append(arg, uint32ToBytes(args[1].(uint32))...)
+		arg = append(arg, uint32ToBytes(uint32(len(filename)))...)
+		arg = append(arg, []byte(filename)...)
+		arg = append(arg, uint64ToBytes(args[3].(uint64))...)
+		if flags & BINLOG_THROUGH_GTID != 0 {
+			arg = append(arg, uint32ToBytes(uint32(len(data)))...)
+			arg = append(arg, data...)
+		}
+
 	default:
 		return fmt.Errorf("Unknown command: %d", command)
 	}
@@ -370,23 +443,43 @@ func (mc *mysqlConn) handleErrorPacket(data []byte) (e error) {
 		return
 	}
 
+	if len(data) < 3 {
+		e = errors.New("Malformed Packet")
+		return
+	}
+
 	pos := 1
+	mysqlErr := new(MySQLError)
 
 	// Error Number [16 bit uint]
-	errno := bytesToUint16(data[pos : pos+2])
+	mysqlErr.Number = bytesToUint16(data[pos : pos+2])
 	pos += 2
 
 	// SQL State [# + 5bytes string]
-	//sqlstate := string(data[pos : pos+6])
-	pos += 6
+	if len(data) >= pos+6 && data[pos] == '#' {
+		mysqlErr.SQLState = string(data[pos+1 : pos+6])
+		pos += 6
+	}
 
 	// Error Message [string]
-	message := string(data[pos:])
+	mysqlErr.Message = string(data[pos:])
 
-	e = fmt.Errorf("Error %d: %s", errno, message)
+	e = mysqlErr
 	return
 }
 
+// MySQLError is an error reported by the server, e.g. a failed query or a
+// binlog dump of a purged file
+type MySQLError struct {
+	Number   uint16
+	SQLState string
+	Message  string
+}
+
+func (e *MySQLError) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.Number, e.Message)
+}
+
 /* Ok Packet 
 Bytes                       Name
 -----                       ----
@@ -443,7 +536,7 @@ The order of packets for a result set is:
 func (mc *mysqlConn) readResultSetHeaderPacket() (fieldCount int, e error) {
 	data, e := mc.readPacket()
 	if e != nil {
-		errLog.Print(`packets:437 `, e)
+		mc.log().Infof("Reading result set header: %v", e)
 		e = driver.ErrBadConn
 		return
 	}
@@ -0,0 +1,40 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Parser decodes binlog events one at a time, for events that come from
+// somewhere else than a connection or a Reader. It keeps the state events
+// depend on, the format description and the table maps, so the events of a
+// stream have to go through the same Parser in order. The parser options,
+// e.g. SetTableFilter or RegisterTable, are set on the Parser. To apply them
+// to the binlog dumps of a connection, see SetParser.
+type Parser struct {
+	*eventParser
+}
+
+func NewParser() (*Parser) {
+	return &Parser{eventParser: newEventParser()}
+}
+
+// NewParserWithLogger returns a Parser logging its diagnostics to logger
+// instead of the package's, see SetLogger. So do the dumps it's set for.
+func NewParserWithLogger(logger Logger) (*Parser) {
+	parser := newEventParser()
+	parser.logger = logger
+	return &Parser{eventParser: parser}
+}
+
+// ParseEvent parses a whole event, from its header to its checksum if any.
+// data is copied first, so the caller can reuse it for the next event.
+func (parser *Parser) ParseEvent(data []byte) (BinlogEvent, error) {
+	if len(data) < eventHeaderSize {
+		return nil, fmt.Errorf("Event of %d bytes is shorter than its header", len(data))
+	}
+	if size := binary.LittleEndian.Uint32(data[9:13]); int(size) != len(data) {
+		return nil, fmt.Errorf("Event size %d doesn't match the %d bytes given", size, len(data))
+	}
+	return parser.parseEvent(append([]byte(nil), data...))
+}
@@ -0,0 +1,216 @@
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Compression types of transaction payloads
+const (
+	TRANSACTION_COMPRESSION_ZSTD uint8 = 0
+	TRANSACTION_COMPRESSION_NONE uint8 = 255
+)
+
+// Field types of the transaction payload header
+const (
+	payloadHeaderEndMark = 0
+	payloadSizeField = 1
+	payloadCompressionTypeField = 2
+	payloadUncompressedSizeField = 3
+)
+
+// Largest uncompressed size of a transaction payload, past which it's taken
+// for corrupt instead of decompressed, as the size comes from the event
+const MAX_UNCOMPRESSED_PAYLOAD_SIZE = 1 << 30
+
+// Decompressor decompresses a transaction payload into the uncompressedSize
+// bytes of its events, at most MAX_UNCOMPRESSED_PAYLOAD_SIZE. Don't allocate
+// uncompressedSize bytes upfront: the decompressed size has yet to match it.
+type Decompressor func(data []byte, uncompressedSize uint64) ([]byte, error)
+
+// Decompressors registered with RegisterDecompressor
+var (
+	decompressors     = make(map[uint8]Decompressor)
+	decompressorsLock sync.RWMutex
+)
+
+// RegisterDecompressor registers the decompressor of transaction payloads
+// compressed with compressionType. The package has no dependencies, so zstd,
+// the only compression MySQL uses, has to be registered to parse compressed
+// transactions, e.g. with github.com/klauspost/compress/zstd:
+//	decoder, _ := zstd.NewReader(nil)
+//	mysql.RegisterDecompressor(mysql.TRANSACTION_COMPRESSION_ZSTD, func(data []byte, size uint64) ([]byte, error) {
+//		return decoder.DecodeAll(data, nil)
+//	})
+func RegisterDecompressor(compressionType uint8, decompress Decompressor) {
+	decompressorsLock.Lock()
+	decompressors[compressionType] = decompress
+	decompressorsLock.Unlock()
+}
+
+// ErrUnsupportedCompression is returned for transaction payloads compressed
+// with a type no decompressor was registered for, see RegisterDecompressor
+type ErrUnsupportedCompression struct {
+	CompressionType uint8
+}
+
+func (e *ErrUnsupportedCompression) Error() string {
+	name := fmt.Sprintf("%d", e.CompressionType)
+	if e.CompressionType == TRANSACTION_COMPRESSION_ZSTD {
+		name = "zstd"
+	}
+	return fmt.Sprintf("No decompressor registered for transaction payloads compressed with %s", name)
+}
+
+
+// Holds the events of a whole transaction, compressed, in place of them when
+// binlog_transaction_compression is ON (MySQL 8.0.20+)
+type TransactionPayloadEvent struct {
+	header EventHeader
+	compressionType uint8
+	uncompressedSize uint64
+	events []BinlogEvent
+}
+
+/* Transaction Payload Event
+Bytes                        Name
+-----                        ----
+  Header fields, each:
+1-9 (Length Coded Binary)    field type, 0 ends the header
+1-9 (Length Coded Binary)    field length
+n                            field value, a Length Coded Binary:
+                               1: payload size
+                               2: compression type
+                               3: uncompressed size
+n                            payload, the transaction's events without
+                             checksums, compressed
+*/
+func (parser *eventParser) parseTransactionPayloadEvent(buf *bytes.Buffer) (event *TransactionPayloadEvent, err error) {
+	event = new(TransactionPayloadEvent)
+	if err = binary.Read(buf, binary.LittleEndian, &event.header); err != nil {
+		return
+	}
+
+	var payloadSize uint64
+	compressionType := uint64(TRANSACTION_COMPRESSION_NONE)
+	for {
+		var fieldType, length uint64
+		if fieldType, _, err = readLengthEncodedInt(buf); err != nil {
+			return
+		}
+		if fieldType == payloadHeaderEndMark {
+			break
+		}
+		if length, _, err = readLengthEncodedInt(buf); err != nil {
+			return
+		}
+		var value []byte
+		if value, err = readBytes(buf, int(length)); err != nil {
+			return
+		}
+		switch fieldType {
+		case payloadSizeField:
+			payloadSize, _, err = readLengthEncodedInt(bytes.NewBuffer(value))
+		case payloadCompressionTypeField:
+			compressionType, _, err = readLengthEncodedInt(bytes.NewBuffer(value))
+		case payloadUncompressedSizeField:
+			event.uncompressedSize, _, err = readLengthEncodedInt(bytes.NewBuffer(value))
+		}
+		// Unknown fields are skipped
+		if err != nil {
+			return
+		}
+	}
+	event.compressionType = uint8(compressionType)
+	// The size is always logged, but the payload also ends with the event
+	if payloadSize == 0 {
+		payloadSize = uint64(buf.Len())
+	}
+	if payloadSize > uint64(buf.Len()) {
+		return nil, io.EOF
+	}
+	payload := buf.Next(int(payloadSize))
+
+	if event.compressionType != TRANSACTION_COMPRESSION_NONE {
+		decompressorsLock.RLock()
+		decompress := decompressors[event.compressionType]
+		decompressorsLock.RUnlock()
+		if decompress == nil {
+			return nil, &ErrUnsupportedCompression{CompressionType: event.compressionType}
+		}
+		if event.uncompressedSize > MAX_UNCOMPRESSED_PAYLOAD_SIZE {
+			return nil, fmt.Errorf("Transaction payload of %d bytes uncompressed is larger than %d", event.uncompressedSize, MAX_UNCOMPRESSED_PAYLOAD_SIZE)
+		}
+		if payload, err = decompress(payload, event.uncompressedSize); err != nil {
+			return
+		}
+		if uint64(len(payload)) != event.uncompressedSize {
+			return nil, fmt.Errorf("Transaction payload decompressed to %d bytes instead of %d", len(payload), event.uncompressedSize)
+		}
+	}
+
+	// The events go through the parser like any other, so that their table
+	// maps are known to the rows events following them
+	for len(payload) > 0 {
+		if len(payload) < eventHeaderSize {
+			return nil, io.EOF
+		}
+		size := binary.LittleEndian.Uint32(payload[9:13])
+		if size < eventHeaderSize || uint64(size) > uint64(len(payload)) {
+			return nil, fmt.Errorf("Invalid event size %d in transaction payload", size)
+		}
+		var inner BinlogEvent
+		if inner, err = parser.parseEventWithoutChecksum(payload[:size]); err != nil {
+			return
+		}
+		event.events = append(event.events, inner)
+		payload = payload[size:]
+	}
+	return
+}
+
+func (event *TransactionPayloadEvent) Header() (*EventHeader) {
+	return &event.header
+}
+
+// Events returns the events of the transaction, in order. Their LogPos isn't
+// a position in the binlog: the transaction ends at the payload event's.
+func (event *TransactionPayloadEvent) Events() ([]BinlogEvent) {
+	return event.events
+}
+
+// CompressionType returns how the payload was compressed, e.g.
+// TRANSACTION_COMPRESSION_ZSTD
+func (event *TransactionPayloadEvent) CompressionType() (uint8) {
+	return event.compressionType
+}
+
+// UncompressedSize returns the size of the events in bytes
+func (event *TransactionPayloadEvent) UncompressedSize() (uint64) {
+	return event.uncompressedSize
+}
+
+func (event *TransactionPayloadEvent) Print() {
+	event.PrintTo(os.Stdout)
+}
+
+func (event *TransactionPayloadEvent) PrintTo(w io.Writer) {
+	event.header.PrintTo(w)
+	fmt.Fprintf(w, "compressionType: %v, uncompressedSize: %v, events: %v\n", event.compressionType, event.uncompressedSize, len(event.events))
+	for _, inner := range event.events {
+		fmt.Fprintln(w)
+		inner.PrintTo(w)
+	}
+}
+
+func (event *TransactionPayloadEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(&event.header, map[string]interface{}{
+		"compressionType": event.compressionType,
+		"uncompressedSize": event.uncompressedSize,
+		"events": event.events,
+	})
+}
@@ -0,0 +1,217 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const eventHeaderSize = 19
+
+// Reader parses binlog events from a stream of events laid out back to back,
+// each starting with its header, as in a binlog file after the magic number
+// (see ReadBinlogFileHeader). The parser options, e.g. SetTableFilter or
+// RegisterTable, are set on the Reader. See NewDumpReader for the events of
+// a binlog dump.
+type Reader struct {
+	*eventParser
+	r io.Reader
+	filename string
+	position uint32
+	// Set by NewDumpReader
+	open func() (driver.Conn, error)
+	serverId uint32
+	// Set by SeekGTID, the dump then starts after it instead of at position
+	gtidSet string
+	dump *readerDump
+	// Returned by Next once the dump ended, until the next Seek
+	dumpErr error
+}
+
+// The binlog dump a Reader from NewDumpReader reads
+type readerDump struct {
+	conn *mysqlConn
+	events chan BinlogEvent
+	done chan error
+}
+
+func NewReader(r io.Reader) (*Reader) {
+	return &Reader{eventParser: newEventParser(), r: r}
+}
+
+// NewDumpReader returns a Reader of the binlog dumped by a connection from
+// open as replica serverId, see DumpBinlogTo, e.g. with db.Driver().Open(dsn)
+// after any setup such as SetHeartbeat. The dump starts at the first Next, at
+// the position set with SetPosition, Seek or SeekGTID, and once it ends Next
+// returns io.EOF or its error until the next Seek. Close stops it.
+func NewDumpReader(open func() (driver.Conn, error), serverId uint32) (*Reader) {
+	return &Reader{eventParser: newEventParser(), open: open, serverId: serverId}
+}
+
+// Position returns the binlog file and the position following the last event
+// read, which is where to resume from after processing it. The file name is
+// only known once a ROTATE_EVENT was read, unless set with SetPosition.
+// Artificial rotations don't move the position.
+func (reader *Reader) Position() (filename string, pos uint32) {
+	return reader.filename, reader.position
+}
+
+// SetPosition sets the file name and position the stream starts at
+func (reader *Reader) SetPosition(filename string, pos uint32) {
+	reader.filename = filename
+	reader.position = pos
+}
+
+// Tracks the position of the stream after event
+func (reader *Reader) updatePosition(event BinlogEvent) {
+	if rotate, ok := event.(*RotateEvent); ok {
+		// Artificial rotations only name the file of a stream that started
+		// without one, see RotateEvent.Artificial
+		if !rotate.Artificial() {
+			reader.filename = rotate.filename
+			reader.position = uint32(rotate.position)
+		} else if reader.filename == "" {
+			reader.filename = rotate.filename
+		}
+		return
+	}
+	// LogPos is 0 for artificial events, which aren't part of the file
+	if pos := event.Header().LogPos; pos != 0 {
+		reader.position = pos
+	}
+}
+
+// Seek makes the dump of a Reader from NewDumpReader start over at position
+// of filename: the running dump is stopped, and the next Next starts a new one
+// on a new connection, whose first events are the master's ROTATE_EVENT to
+// filename and FORMAT_DESCRIPTION_EVENT. The table maps of the previous dump
+// are forgotten, so position should be the start of a transaction.
+func (reader *Reader) Seek(filename string, position uint32) error {
+	if reader.open == nil {
+		return errors.New("Seek on a Reader without a binlog dump")
+	}
+	e := reader.stopDump()
+	reader.SetPosition(filename, position)
+	reader.gtidSet = ""
+	return e
+}
+
+// SeekGTID is like Seek, but the new dump starts with the first transaction
+// missing from gtidSet, see DumpBinlogGTID. The position is unknown until the
+// master's rotation names the file.
+func (reader *Reader) SeekGTID(gtidSet string) error {
+	if reader.open == nil {
+		return errors.New("SeekGTID on a Reader without a binlog dump")
+	}
+	if _, e := ParseGTIDSet(gtidSet); e != nil {
+		return e
+	}
+	e := reader.stopDump()
+	reader.SetPosition("", 0)
+	reader.gtidSet = gtidSet
+	return e
+}
+
+// Close stops the dump of a Reader from NewDumpReader, if running, and closes
+// its connection
+func (reader *Reader) Close() error {
+	return reader.stopDump()
+}
+
+// Starts the dump from the position of the reader
+func (reader *Reader) startDump() error {
+	conn, e := reader.open()
+	if e != nil {
+		return e
+	}
+	mc, ok := conn.(*mysqlConn)
+	if !ok {
+		conn.Close()
+		return fmt.Errorf("Binlog dump on a connection of type %T", conn)
+	}
+	mc.SetParser(&Parser{eventParser: reader.eventParser})
+
+	dump := &readerDump{conn: mc, events: make(chan BinlogEvent), done: make(chan error, 1)}
+	filename, position, gtidSet := reader.filename, reader.position, reader.gtidSet
+	go func() {
+		if gtidSet != "" {
+			dump.done <- mc.DumpBinlogGTID(context.Background(), reader.serverId, gtidSet, dump.events)
+		} else {
+			dump.done <- mc.DumpBinlogTo(context.Background(), reader.serverId, filename, position, dump.events)
+		}
+		close(dump.events)
+	}()
+	reader.dump = dump
+	return nil
+}
+
+// Stops the running dump, if any, and forgets how it ended
+func (reader *Reader) stopDump() (e error) {
+	dump := reader.dump
+	reader.dump, reader.dumpErr = nil, nil
+	if dump == nil {
+		return nil
+	}
+	e = dump.conn.StopDump()
+	for range dump.events {
+	}
+	return
+}
+
+// Receives the next event of the dump, starting it if needed
+func (reader *Reader) nextDumped() (BinlogEvent, error) {
+	if reader.dumpErr != nil {
+		return nil, reader.dumpErr
+	}
+	if reader.dump == nil {
+		if e := reader.startDump(); e != nil {
+			return nil, e
+		}
+	}
+	event, ok := <-reader.dump.events
+	if !ok {
+		if reader.dumpErr = <-reader.dump.done; reader.dumpErr == nil {
+			reader.dumpErr = io.EOF
+		}
+		reader.dump.conn.Close()
+		reader.dump = nil
+		return nil, reader.dumpErr
+	}
+	reader.updatePosition(event)
+	return event, nil
+}
+
+// Next reads and parses the next event. It returns io.EOF if the stream ends
+// between two events, and io.ErrUnexpectedEOF if it ends inside one.
+func (reader *Reader) Next() (BinlogEvent, error) {
+	if reader.open != nil {
+		return reader.nextDumped()
+	}
+	header := make([]byte, eventHeaderSize)
+	if _, e := io.ReadFull(reader.r, header); e != nil {
+		return nil, e
+	}
+
+	size := binary.LittleEndian.Uint32(header[9:13])
+	if size < eventHeaderSize {
+		return nil, fmt.Errorf("Invalid event size %d", size)
+	}
+	data := make([]byte, size)
+	copy(data, header)
+	if _, e := io.ReadFull(reader.r, data[eventHeaderSize:]); e != nil {
+		if e == io.EOF {
+			e = io.ErrUnexpectedEOF
+		}
+		return nil, e
+	}
+
+	event, e := reader.parseEvent(data)
+	if e != nil {
+		return nil, e
+	}
+	reader.updatePosition(event)
+	return event, nil
+}
@@ -0,0 +1,138 @@
+package mysql
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestReaderPositionRotations(t *testing.T) {
+	xid := atLogPos(makeEvent(XID_EVENT, 9, 0, 0, 0, 0, 0, 0, 0), 300)
+	stream := bytes.NewReader(concat(
+		makeRotate("binlog.000001", 4, true),
+		xid,
+		makeRotate("binlog.000002", 4, false),
+		makeRotate("binlog.000002", 4, true)))
+
+	reader := NewReader(stream)
+	reader.SetPosition("binlog.000001", 120)
+	want := []struct {
+		filename string
+		position uint32
+	}{
+		// The artificial rotation at the start of a dump isn't a position
+		{"binlog.000001", 120},
+		{"binlog.000001", 300},
+		{"binlog.000002", 4},
+		{"binlog.000002", 4},
+	}
+	for i, w := range want {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if filename, position := reader.Position(); filename != w.filename || position != w.position {
+			t.Errorf("position after event %d %s:%d, want %s:%d", i, filename, position, w.filename, w.position)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("err %v, want io.EOF", err)
+	}
+
+	// Streams started without a file name learn it from the artificial rotation
+	reader = NewReader(bytes.NewReader(makeRotate("binlog.000003", 4, true)))
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if filename, position := reader.Position(); filename != "binlog.000003" || position != 0 {
+		t.Errorf("position %s:%d, want binlog.000003:0", filename, position)
+	}
+}
+
+func TestDumpReaderSeek(t *testing.T) {
+	// The transactions of binlog.000001, from position 4
+	var binlog [][]byte
+	start := []uint32{}
+	position := uint32(4)
+	for i := 0; i < 3; i++ {
+		xid := makeEvent(XID_EVENT, byte(i), 0, 0, 0, 0, 0, 0, 0)
+		start = append(start, position)
+		position += uint32(len(xid))
+		binlog = append(binlog, atLogPos(xid, position))
+	}
+	// Serves binlog from the position of the dump command, or all of it for
+	// GTID dumps, as a master does
+	commands := make(chan []byte, 3)
+	reader := NewDumpReader(func() (driver.Conn, error) {
+		mc, master, err := newTestTCPConn()
+		if err != nil {
+			return nil, err
+		}
+		go master.serveDumpOf(func(command []byte) [][]byte {
+			commands <- command
+			events := [][]byte{makeRotate("binlog.000001", 4, true)}
+			for i, event := range binlog {
+				if command[0] != byte(COM_BINLOG_DUMP) || start[i] >= binary.LittleEndian.Uint32(command[1:]) {
+					events = append(events, event)
+				}
+			}
+			return events
+		})
+		return mc, nil
+	}, 1)
+	defer reader.Close()
+
+	// Returns the start of the next event that isn't the rotation
+	next := func() uint32 {
+		t.Helper()
+		for {
+			event, err := reader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := event.(*RotateEvent); !ok {
+				return event.Header().LogPos - event.Header().EventSize
+			}
+		}
+	}
+
+	reader.SetPosition("binlog.000001", 4)
+	if pos := next(); pos != 4 {
+		t.Fatalf("first event at %d, want 4", pos)
+	}
+	<-commands
+
+	if err := reader.Seek("binlog.000001", start[2]); err != nil {
+		t.Fatal(err)
+	}
+	if pos := next(); pos != start[2] {
+		t.Errorf("event at %d after Seek, want %d", pos, start[2])
+	}
+	if command := <-commands; string(command[11:]) != "binlog.000001" || binary.LittleEndian.Uint32(command[1:]) != start[2] {
+		t.Errorf("dump command %q, want binlog.000001 at %d", command, start[2])
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("err %v at the end of the dump, want io.EOF", err)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("err %v after the end of the dump, want io.EOF", err)
+	}
+
+	if err := reader.SeekGTID("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-2"); err != nil {
+		t.Fatal(err)
+	}
+	if pos := next(); pos != 4 {
+		t.Errorf("event at %d after SeekGTID, want 4", pos)
+	}
+	if command := <-commands; command[0] != byte(COM_BINLOG_DUMP_GTID) {
+		t.Errorf("dump command %q, want COM_BINLOG_DUMP_GTID", command)
+	}
+	if filename, position := reader.Position(); filename != "binlog.000001" || position != start[1] {
+		t.Errorf("position %s:%d, want binlog.000001:%d", filename, position, start[1])
+	}
+
+	if err := NewReader(bytes.NewReader(nil)).Seek("binlog.000001", 4); err == nil {
+		t.Error("Seek on a Reader of a file")
+	}
+}
@@ -0,0 +1,175 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ReconnectOptions configures DumpBinlogReconnect
+type ReconnectOptions struct {
+	// Reconnects in a row that may fail before giving up, 0 for no limit
+	MaxAttempts int
+	// Wait before the first reconnect, doubled after every failed one up to
+	// MaxBackoff. Default 1 second.
+	MinBackoff time.Duration
+	// Default 1 minute
+	MaxBackoff time.Duration
+	// Called with every new connection before the dump starts, e.g. to call
+	// SetHeartbeat, EnableSemiSync or SetReplicaUUID on it
+	Setup func(conn driver.Conn) error
+	// Parses the events of every connection, see SetParser. Default a parser
+	// with the default options.
+	Parser *Parser
+	// Logs the reconnects and the messages of every connection. Default the
+	// package's, see SetLogger.
+	Logger Logger
+}
+
+// DumpBinlogReconnect is like DumpBinlogTo, but opens its own connection with
+// dsn and, when the connection is lost, reconnects and resumes the dump. It
+// only returns once ctx is done, the master sends EOF or on errors other than
+// a lost connection, e.g. a MySQLError from the master.
+//
+// The dump resumes at the end of the last transaction sent to out (or at the
+// last rotation), since a transaction's rows events can't be parsed without
+// the table maps logged at its start. The events of a transaction cut off by
+// the reconnect are therefore sent again, preceded by the ROTATE_EVENT and
+// FORMAT_DESCRIPTION_EVENT the master starts every dump with: delivery is at
+// least once.
+func DumpBinlogReconnect(ctx context.Context, dsn string, serverId uint32, filename string, position uint32, options ReconnectOptions, out chan<- BinlogEvent) error {
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = time.Second
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = time.Minute
+	}
+
+	resume := &resumePosition{filename: filename, position: position}
+	backoff := options.MinBackoff
+	for attempt := 0; ; attempt++ {
+		received, e := dumpBinlogOnce(ctx, dsn, serverId, resume, options, out)
+		if received {
+			attempt, backoff = 0, options.MinBackoff
+		}
+		if e == nil || ctx.Err() != nil || !isConnectionLost(e) {
+			return e
+		}
+		if options.MaxAttempts > 0 && attempt >= options.MaxAttempts {
+			return e
+		}
+		log := options.Logger
+		if log == nil {
+			log = packageLogger()
+		}
+		log.Infof("Binlog dump connection lost (%v), reconnecting in %v", e, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > options.MaxBackoff {
+			backoff = options.MaxBackoff
+		}
+	}
+}
+
+// Opens a connection and dumps the binlog from resume until an error, keeping
+// resume up to date. Tells whether any event was received.
+func dumpBinlogOnce(ctx context.Context, dsn string, serverId uint32, resume *resumePosition, options ReconnectOptions, out chan<- BinlogEvent) (received bool, e error) {
+	conn, e := (&mysqlDriver{}).Open(dsn)
+	if e != nil {
+		return false, e
+	}
+	mc := conn.(*mysqlConn)
+	defer mc.Close()
+	mc.SetParser(options.Parser)
+	mc.SetLogger(options.Logger)
+	if options.Setup != nil {
+		if e = options.Setup(conn); e != nil {
+			return false, e
+		}
+	}
+
+	dumpCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan BinlogEvent)
+	done := make(chan error, 1)
+	go func() {
+		done <- mc.DumpBinlogTo(dumpCtx, serverId, resume.filename, resume.position, events)
+		close(events)
+	}()
+
+	for event := range events {
+		received = true
+		select {
+		case out <- event:
+			resume.update(event)
+		case <-ctx.Done():
+			cancel()
+			for range events {
+			}
+		}
+	}
+	return received, <-done
+}
+
+// The position a dump can resume at, tracked through the events sent
+type resumePosition struct {
+	filename string
+	position uint32
+	inTransaction bool
+}
+
+func (resume *resumePosition) update(event BinlogEvent) {
+	switch event := event.(type) {
+	case *RotateEvent:
+		// Artificial rotations only name the file of a dump that started
+		// without one, see RotateEvent.Artificial
+		if !event.Artificial() {
+			resume.filename = event.filename
+			resume.position = uint32(event.position)
+		} else if resume.filename == "" {
+			resume.filename = event.filename
+		}
+		resume.inTransaction = false
+		return
+	case *XIDEvent, *TransactionPayloadEvent:
+		resume.inTransaction = false
+	case *MariaDBGTIDEvent:
+		// Stands for the "BEGIN" of transactions
+		if !event.Standalone() {
+			resume.inTransaction = true
+		}
+		return
+	case *QueryEvent:
+		switch query := strings.ToUpper(strings.TrimSpace(event.query)); {
+		case query == "BEGIN":
+			resume.inTransaction = true
+			return
+		case query == "COMMIT", query == "ROLLBACK":
+			resume.inTransaction = false
+		case resume.inTransaction:
+			return
+		}
+		// Statements outside transactions, e.g. DDL, end where they are
+	default:
+		return
+	}
+	if event.Header().LogPos != 0 {
+		resume.position = event.Header().LogPos
+	}
+}
+
+// Whether a dump error means the connection to the master was lost
+func isConnectionLost(e error) bool {
+	switch e.(type) {
+	case *ErrHeartbeatTimeout, net.Error:
+		return true
+	}
+	return e == driver.ErrBadConn || e == io.EOF || e == io.ErrUnexpectedEOF
+}
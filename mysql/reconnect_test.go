@@ -0,0 +1,74 @@
+package mysql
+
+import (
+	"testing"
+)
+
+// Returns the event parsed from data
+func mustParseEvent(t *testing.T, data []byte) BinlogEvent {
+	event, err := newEventParser().parseEvent(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestResumePositionRotations(t *testing.T) {
+	resume := &resumePosition{filename: "binlog.000001", position: 120}
+	resume.update(mustParseEvent(t, makeRotate("binlog.000001", 4, true)))
+	if resume.filename != "binlog.000001" || resume.position != 120 {
+		t.Errorf("resume at %s:%d after an artificial rotation, want binlog.000001:120", resume.filename, resume.position)
+	}
+	resume.update(mustParseEvent(t, makeRotate("binlog.000002", 4, false)))
+	if resume.filename != "binlog.000002" || resume.position != 4 {
+		t.Errorf("resume at %s:%d after a rotation, want binlog.000002:4", resume.filename, resume.position)
+	}
+
+	resume = &resumePosition{}
+	resume.update(mustParseEvent(t, makeRotate("binlog.000003", 4, true)))
+	if resume.filename != "binlog.000003" || resume.position != 0 {
+		t.Errorf("resume at %s:%d, want the file name of the artificial rotation only", resume.filename, resume.position)
+	}
+}
+
+func TestResumePositionTransactions(t *testing.T) {
+	xid := makeEvent(XID_EVENT, 9, 0, 0, 0, 0, 0, 0, 0)
+	mariaDBGTID := makeEvent(MARIADB_GTID_EVENT, 100, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	mariaDBParser := newEventParser()
+	mariaDBParser.SetFlavor(FLAVOR_MARIADB)
+	mariaDBEvent, err := mariaDBParser.parseEvent(atLogPos(mariaDBGTID, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resume := &resumePosition{filename: "binlog.000001", position: 120}
+	steps := []struct {
+		event BinlogEvent
+		filename string
+		position uint32
+	}{
+		// Inside a transaction the position stays at its start
+		{mustParseEvent(t, atLogPos(makeQuery("BEGIN"), 200)), "binlog.000001", 120},
+		{mustParseEvent(t, atLogPos(testTableMap, 300)), "binlog.000001", 120},
+		{mustParseEvent(t, atLogPos(xid, 500)), "binlog.000001", 500},
+		// Statements outside transactions end where they are
+		{mustParseEvent(t, atLogPos(makeQuery("CREATE TABLE t (id INT)"), 600)), "binlog.000001", 600},
+		{mustParseEvent(t, atLogPos(makeQuery("begin"), 700)), "binlog.000001", 600},
+		{mustParseEvent(t, atLogPos(makeQuery("INSERT INTO t VALUES (1)"), 800)), "binlog.000001", 600},
+		{mustParseEvent(t, atLogPos(makeQuery("COMMIT"), 900)), "binlog.000001", 900},
+		{mariaDBEvent, "binlog.000001", 900},
+		{mustParseEvent(t, atLogPos(makeQuery("INSERT INTO t VALUES (2)"), 1100)), "binlog.000001", 900},
+		{mustParseEvent(t, atLogPos(xid, 1200)), "binlog.000001", 1200},
+		{mustParseEvent(t, makeRotate("binlog.000002", 4, false)), "binlog.000002", 4},
+		{mustParseEvent(t, atLogPos(makeQuery("BEGIN"), 200)), "binlog.000002", 4},
+	}
+	for i, step := range steps {
+		resume.update(step.event)
+		if resume.filename != step.filename || resume.position != step.position {
+			t.Errorf("resume at %s:%d after event %d, want %s:%d", resume.filename, resume.position, i, step.filename, step.position)
+		}
+	}
+	if !resume.inTransaction {
+		t.Error("not in the transaction begun last")
+	}
+}
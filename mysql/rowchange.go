@@ -0,0 +1,282 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RowChange is the change of a single row, as logged by a rows event
+type RowChange struct {
+	Schema string
+	Table string
+	Action RowsOperation
+	// The row before the change, nil for inserts
+	Before []driver.Value
+	// The row after the change, nil for deletes
+	After []driver.Value
+	// When the statement making the change started on the master
+	Timestamp time.Time
+	// Position following the rows event in the master's binlog
+	LogPos uint32
+}
+
+// RowChanges returns a RowChange for every row of the event. Values are in
+// column order, see TableMapEvent.Columns for the columns. Events parsed in
+// raw mode or with their table filtered out have no rows, thus no changes.
+func (event *RowsEvent) RowChanges() ([]RowChange, error) {
+	action := event.Operation()
+	rows := event.rows
+	if action == ROWS_UPDATE {
+		pairs, e := event.UpdatePairs()
+		if e != nil {
+			return nil, e
+		}
+		changes := make([]RowChange, len(pairs))
+		for i, pair := range pairs {
+			changes[i] = event.rowChange(pair.Before, pair.After)
+		}
+		return changes, nil
+	}
+
+	changes := make([]RowChange, len(rows))
+	for i, row := range rows {
+		if action == ROWS_DELETE {
+			changes[i] = event.rowChange(row, nil)
+		} else {
+			changes[i] = event.rowChange(nil, row)
+		}
+	}
+	return changes, nil
+}
+
+func (event *RowsEvent) rowChange(before, after []driver.Value) RowChange {
+	return RowChange{
+		Schema: event.SchemaName(),
+		Table: event.TableName(),
+		Action: event.Operation(),
+		Before: before,
+		After: after,
+		Timestamp: time.Unix(int64(event.header.Timestamp), 0),
+		LogPos: event.header.LogPos,
+	}
+}
+
+// Transaction holds the row changes of a committed transaction, in order
+type Transaction struct {
+	// Empty unless the master logs GTIDs, i.e. gtid_mode is ON, in the format
+	// of the master's flavor, e.g. "0-1-100" for MariaDB
+	GTID string
+	// When the transaction committed: from the GTID event on MySQL 8.0.1+,
+	// otherwise the second its commit was logged
+	Timestamp time.Time
+	// Position following the commit in the master's binlog
+	LogPos uint32
+	Changes []RowChange
+}
+
+// ChangeStream turns binlog events, e.g. from DumpBinlogTo or a Reader, into
+// row changes for the callbacks set with OnRowChange and OnTransaction. The
+// parser matches every rows event with the TABLE_MAP_EVENT preceding it, so
+// the events have to come from a single parser, in order.
+type ChangeStream struct {
+	onRowChange func(change RowChange) error
+	onTransaction func(tx Transaction) error
+	// The transaction in progress, see Process
+	tx *Transaction
+	commitTimestamp time.Time
+	begun bool
+	// The TRANSACTION_PAYLOAD_EVENT being processed, if any
+	payload *EventHeader
+	// When the master logged the last event processed, see Lag
+	loggedAt time.Time
+	lagLock sync.Mutex
+}
+
+func NewChangeStream() (*ChangeStream) {
+	return &ChangeStream{}
+}
+
+// OnRowChange sets the function called with every row change, in binlog order.
+// An error stops Process and Run, which return it.
+func (stream *ChangeStream) OnRowChange(f func(change RowChange) error) {
+	stream.onRowChange = f
+}
+
+// OnTransaction sets the function called with the row changes of every
+// transaction once it commits, after OnRowChange was called with them.
+// Transactions without row changes, e.g. DDL, and rolled back ones are left
+// out. An error stops Process and Run, which return it.
+func (stream *ChangeStream) OnTransaction(f func(tx Transaction) error) {
+	stream.onTransaction = f
+}
+
+// Process passes the row changes of event to the callbacks. A transaction
+// starts at a GTID_EVENT, an ANONYMOUS_GTID_EVENT, a MARIADB_GTID_EVENT or a
+// "BEGIN" QUERY_EVENT
+// and ends at an XID_EVENT or a "COMMIT" QUERY_EVENT, possibly inside a
+// TRANSACTION_PAYLOAD_EVENT. A ROTATE_EVENT drops the transaction in
+// progress, since a dump restarted in the middle of one sends it again from
+// its start.
+func (stream *ChangeStream) Process(event BinlogEvent) error {
+	if stream.payload == nil {
+		stream.trackLag(event.Header())
+	}
+	switch event := event.(type) {
+	case *GTIDEvent:
+		stream.begin(event.GTID(), event.immediateCommitTimestamp, false)
+	case *AnonymousGTIDEvent:
+		stream.begin("", event.immediateCommitTimestamp, false)
+	case *MariaDBGTIDEvent:
+		// MariaDB logs no "BEGIN" after the GTID of a transaction
+		stream.begin(event.GTID().String(), 0, !event.Standalone())
+
+	case *QueryEvent:
+		switch query := strings.ToUpper(strings.TrimSpace(event.query)); {
+		case query == "BEGIN":
+			if stream.tx == nil {
+				stream.tx = &Transaction{}
+				stream.commitTimestamp = time.Time{}
+			}
+			stream.begun = true
+		case query == "COMMIT":
+			return stream.commit(event.Header())
+		case query == "ROLLBACK", !stream.begun:
+			// Statements logged outside a BEGIN, e.g. DDL, are a transaction of
+			// their own
+			stream.tx, stream.begun = nil, false
+		}
+
+	case *XIDEvent:
+		return stream.commit(event.Header())
+
+	case *RotateEvent:
+		stream.tx, stream.begun = nil, false
+
+	case *RowsEvent:
+		return stream.processRows(event)
+
+	case *TransactionPayloadEvent:
+		// The events in the payload have no binlog position of their own
+		stream.payload = event.Header()
+		defer func() { stream.payload = nil }()
+		for _, inner := range event.Events() {
+			if e := stream.Process(inner); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Lag returns the time elapsed since the master logged the last event
+// processed, i.e. how far behind the master the stream is, or 0 before the
+// first event. Heartbeats count as logged when processed, since the master only
+// sends them once the replica has all events: while the master is idle, the lag
+// stays under the heartbeat period, see SetHeartbeat. Artificial events and
+// format descriptions don't count. Lag may be called while Run is processing.
+func (stream *ChangeStream) Lag() (time.Duration) {
+	stream.lagLock.Lock()
+	defer stream.lagLock.Unlock()
+	if stream.loggedAt.IsZero() {
+		return 0
+	}
+	return time.Since(stream.loggedAt)
+}
+
+// Sets when the master logged the event of header, for Lag
+func (stream *ChangeStream) trackLag(header *EventHeader) {
+	var loggedAt time.Time
+	switch {
+	case header.EventType == HEARTBEAT_EVENT:
+		loggedAt = time.Now()
+	case header.EventType == FORMAT_DESCRIPTION_EVENT, header.Flags & LOG_EVENT_ARTIFICIAL_F != 0, header.Timestamp == 0:
+		return
+	default:
+		loggedAt = time.Unix(int64(header.Timestamp), 0)
+	}
+	stream.lagLock.Lock()
+	stream.loggedAt = loggedAt
+	stream.lagLock.Unlock()
+}
+
+// Returns the position following the event of header in the binlog
+func (stream *ChangeStream) logPos(header *EventHeader) uint32 {
+	if stream.payload != nil {
+		return stream.payload.LogPos
+	}
+	return header.LogPos
+}
+
+// Starts a transaction at its GTID event. commitTimestamp is in microseconds
+// since the epoch, 0 if not logged, and begun tells whether the transaction
+// has no "BEGIN" QUERY_EVENT to wait for.
+func (stream *ChangeStream) begin(gtid string, commitTimestamp uint64, begun bool) {
+	stream.tx = &Transaction{GTID: gtid}
+	stream.commitTimestamp = time.Time{}
+	if commitTimestamp != 0 {
+		stream.commitTimestamp = time.UnixMicro(int64(commitTimestamp))
+	}
+	stream.begun = begun
+}
+
+func (stream *ChangeStream) processRows(event *RowsEvent) error {
+	if stream.onRowChange == nil && stream.onTransaction == nil {
+		return nil
+	}
+	changes, e := event.RowChanges()
+	if e != nil {
+		return e
+	}
+	if stream.payload != nil {
+		for i := range changes {
+			changes[i].LogPos = stream.payload.LogPos
+		}
+	}
+	if stream.onRowChange != nil {
+		for _, change := range changes {
+			if e = stream.onRowChange(change); e != nil {
+				return e
+			}
+		}
+	}
+	if stream.tx != nil && stream.onTransaction != nil {
+		stream.tx.Changes = append(stream.tx.Changes, changes...)
+	}
+	return nil
+}
+
+// Ends the transaction in progress with the commit event of header
+func (stream *ChangeStream) commit(header *EventHeader) error {
+	tx := stream.tx
+	stream.tx, stream.begun = nil, false
+	if tx == nil || len(tx.Changes) == 0 || stream.onTransaction == nil {
+		return nil
+	}
+	tx.Timestamp = stream.commitTimestamp
+	if tx.Timestamp.IsZero() {
+		tx.Timestamp = time.Unix(int64(header.Timestamp), 0)
+	}
+	tx.LogPos = stream.logPos(header)
+	return stream.onTransaction(*tx)
+}
+
+// Run processes the events received from in until it is closed, a callback
+// fails or ctx is done. It returns nil once in is closed.
+func (stream *ChangeStream) Run(ctx context.Context, in <-chan BinlogEvent) error {
+	for {
+		select {
+		case event, ok := <-in:
+			if !ok {
+				return nil
+			}
+			if e := stream.Process(event); e != nil {
+				return e
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
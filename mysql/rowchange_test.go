@@ -0,0 +1,54 @@
+package mysql
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// Returns a copy of the event with its timestamp set
+func atTimestamp(event []byte, timestamp time.Time) []byte {
+	event = append([]byte{}, event...)
+	binary.LittleEndian.PutUint32(event, uint32(timestamp.Unix()))
+	return event
+}
+
+func TestChangeStreamLag(t *testing.T) {
+	parser := NewParser()
+	stream := NewChangeStream()
+	process := func(data []byte) {
+		t.Helper()
+		event, err := parser.ParseEvent(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stream.Process(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Timestamps have whole seconds
+	within := func(want time.Duration) {
+		t.Helper()
+		if lag := stream.Lag(); lag < want || lag > want + 2 * time.Second {
+			t.Errorf("lag %v, want %v", lag, want)
+		}
+	}
+
+	if lag := stream.Lag(); lag != 0 {
+		t.Errorf("lag %v before any event, want 0", lag)
+	}
+	now := time.Now()
+	process(atTimestamp(makeQuery("BEGIN"), now.Add(-time.Hour)))
+	within(time.Hour)
+	process(atTimestamp(makeEvent(XID_EVENT, 9, 0, 0, 0, 0, 0, 0, 0), now.Add(-10 * time.Second)))
+	within(10 * time.Second)
+
+	// The master's current time isn't when the events were logged
+	process(atTimestamp(makeRotate("binlog.000002", 4, true), now))
+	process(atTimestamp(makeFormatDescription("8.0.32-log", BINLOG_CHECKSUM_ALG_OFF), now))
+	within(10 * time.Second)
+
+	// Heartbeats are sent when the replica is caught up, and have no timestamp
+	process(makeEvent(HEARTBEAT_EVENT, []byte("binlog.000002")...))
+	within(0)
+}
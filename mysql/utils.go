@@ -11,27 +11,97 @@ package mysql
 import (
 	"bytes"
 	"crypto/sha1"
+	"crypto/tls"
+	"fmt"
 	"io"
 	"log"
 	"math"
-	"os"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
-	"encoding/binary"
+	"sync"
 	"errors"
 )
 
-// Logger
+// Logger receives the diagnostics of the package, see SetLogger
+type Logger interface {
+	// Debugf logs details for troubleshooting, e.g. the start of a binlog dump
+	Debugf(format string, args ...interface{})
+	// Infof logs what is worth knowing in production, e.g. the network errors
+	// connections return as driver.ErrBadConn
+	Infof(format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{}) {}
+
+// The logger set with SetLogger
 var (
-	errLog *log.Logger
-	dbgLog *log.Logger
+	logger     Logger = nopLogger{}
+	loggerLock sync.RWMutex
 )
 
-func init() {
-	errLog = log.New(os.Stderr, "[MySQL] ", log.LstdFlags)
-	dbgLog = log.New(os.Stdout, "[MySQL] ", log.LstdFlags)
+// SetLogger sets the logger of the package, which logs nothing by default,
+// e.g. to log to stderr:
+//	mysql.SetLogger(mysql.NewStdLogger(log.New(os.Stderr, "[MySQL] ", log.LstdFlags), false))
+// nil restores the default. Connections and parsers can log elsewhere, see the
+// SetLogger method of the connections and NewParserWithLogger.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	loggerLock.Lock()
+	logger = l
+	loggerLock.Unlock()
+}
+
+// Returns the logger set with SetLogger
+func packageLogger() (Logger) {
+	loggerLock.RLock()
+	defer loggerLock.RUnlock()
+	return logger
+}
+
+// SetLogger sets the logger of the connection, e.g. to tag its messages with
+// the master they come from, instead of the package's. The messages of opening
+// the connection go to the package's logger. nil restores the package's.
+func (mc *mysqlConn) SetLogger(l Logger) {
+	mc.logger = l
+}
+
+// Returns the logger of the connection
+func (mc *mysqlConn) log() (Logger) {
+	if mc.logger != nil {
+		return mc.logger
+	}
+	return packageLogger()
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	debug bool
+}
+
+// NewStdLogger returns a Logger writing to l, including the debug messages if
+// debug is set
+func NewStdLogger(l *log.Logger, debug bool) (Logger) {
+	return &stdLogger{logger: l, debug: debug}
+}
+
+func (l *stdLogger) Debugf(format string, args ...interface{}) {
+	if l.debug {
+		l.logger.Printf(format, args...)
+	}
+}
+
+func (l *stdLogger) Infof(format string, args ...interface{}) {
+	l.logger.Printf(format, args...)
+}
 
+func init() {
 	dsnPattern = regexp.MustCompile(
 		`^(?:(?P<user>.*?)(?::(?P<passwd>.*))?@)?` + // [user[:password]@]
 			`(?:(?P<net>[^\(]*)(?:\((?P<addr>[^\)]*)\))?)?` + // [net[(addr)]]
@@ -85,6 +155,61 @@ func parseDSN(dsn string) *config {
 	return cfg
 }
 
+// TLS configs registered with RegisterTLSConfig
+var (
+	tlsConfigs     = make(map[string]*tls.Config)
+	tlsConfigsLock sync.RWMutex
+)
+
+// RegisterTLSConfig registers a TLS config for DSNs with tls=name, e.g. to use
+// a private CA or client certificates. The names "true", "false" and
+// "skip-verify" are reserved.
+func RegisterTLSConfig(name string, config *tls.Config) error {
+	switch name {
+	case "true", "false", "skip-verify":
+		return fmt.Errorf("TLS config name %q is reserved", name)
+	}
+	tlsConfigsLock.Lock()
+	tlsConfigs[name] = config
+	tlsConfigsLock.Unlock()
+	return nil
+}
+
+// Returns the TLS config the DSN's tls parameter asks for, or nil for none:
+//	tls=true          verify the server's certificate against the system roots
+//	tls=skip-verify   encrypt, but accept any certificate
+//	tls=name          use the config registered with RegisterTLSConfig
+// The connection fails if TLS is asked for and the server doesn't support it.
+func parseTLSParam(cfg *config) (*tls.Config, error) {
+	var tlsConfig *tls.Config
+	switch value, ok := cfg.params["tls"]; {
+	case !ok, value == "false":
+		return nil, nil
+	case value == "true":
+		tlsConfig = &tls.Config{}
+	case value == "skip-verify":
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	default:
+		tlsConfigsLock.RLock()
+		registered := tlsConfigs[value]
+		tlsConfigsLock.RUnlock()
+		if registered == nil {
+			return nil, fmt.Errorf("Invalid value / unknown config name: tls=%s", value)
+		}
+		tlsConfig = registered.Clone()
+	}
+
+	// Certificates are verified against the host name of the DSN
+	if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+		host, _, e := net.SplitHostPort(cfg.addr)
+		if e != nil {
+			host = cfg.addr
+		}
+		tlsConfig.ServerName = host
+	}
+	return tlsConfig, nil
+}
+
 // Encrypt password using 4.1+ method
 // http://forge.mysql.com/wiki/MySQL_Internals_ClientServer_Protocol#4.1_and_later
 func scramblePassword(scramble, password []byte) (result []byte) {
@@ -176,6 +301,8 @@ func readAndDropLengthCodedBinary(data []byte) (n int, e error) {
 	return
 }
 
+// 0xff doesn't start a length encoded integer: it marks error packets
+var errUndefinedLengthEncodedInt = errors.New("undefined value (0xff) length encoded integer")
 
 func readLengthEncodedInt(buf *bytes.Buffer) (num uint64, isNull bool, e error) {
 	var b byte
@@ -200,9 +327,7 @@ func readLengthEncodedInt(buf *bytes.Buffer) (num uint64, isNull bool, e error)
 
 	// 252: value of following 2
 	case b == 252:
-		var num16 uint16
-		binary.Read(buf, binary.LittleEndian, &num16)
-		num = uint64(num16)
+		num, e = readFixedLengthInteger(buf, 2)
 		return
 
 	// 253: value of following 3
@@ -212,11 +337,11 @@ func readLengthEncodedInt(buf *bytes.Buffer) (num uint64, isNull bool, e error)
 
 	// 254: value of following 8
 	case b == 254:
-		e = binary.Read(buf, binary.LittleEndian, &num)
+		num, e = readFixedLengthInteger(buf, 8)
 		return
 
 	default:
-		e = errors.New("undefined value (0xff) length encoded integer")
+		e = errUndefinedLengthEncodedInt
 		return
 	}
 
@@ -257,7 +382,7 @@ func readFixedLengthInteger(buf *bytes.Buffer, size int) (num uint64, err error)
 	var b byte
 	num = 0
 	if (buf.Len() < size) {
-		return 0, io.EOF
+		return 0, &ErrTruncatedEvent{ReadSize: size, Remaining: buf.Len()}
 	}
 	for i := uint(0); i < uint(size); i++ {
 		b, err = buf.ReadByte()
@@ -313,6 +438,11 @@ func float64ToBytes(f float64) []byte {
 }
 
 func bytesToLengthCodedBinary(b []byte) (length uint64, n int, e error) {
+	if len(b) == 0 {
+		e = io.EOF
+		return
+	}
+
 	switch {
 
 	// 0-250: value of first byte
@@ -338,6 +468,10 @@ func bytesToLengthCodedBinary(b []byte) (length uint64, n int, e error) {
 	// 254: value of following 8
 	case b[0] == 254:
 		n = 9
+
+	default:
+		e = errUndefinedLengthEncodedInt
+		return
 	}
 
 	if len(b) < n {
@@ -0,0 +1,119 @@
+package mysql
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Keeps the messages logged
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Infof(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func TestConnectionLogger(t *testing.T) {
+	global := &testLogger{}
+	SetLogger(global)
+	defer SetLogger(nil)
+
+	mc, master := newTestConn()
+	master.conn.Close()
+	connLogger := &testLogger{}
+	mc.SetLogger(connLogger)
+	if _, err := mc.readPacket(); err == nil {
+		t.Fatal("read from a closed connection")
+	}
+	if len(connLogger.messages) != 1 || len(global.messages) != 0 {
+		t.Errorf("connection logged %q and package %q, want one message from the connection", connLogger.messages, global.messages)
+	}
+
+	// Without its own, the connection logs to the package's
+	mc.SetLogger(nil)
+	mc.readPacket()
+	if len(connLogger.messages) != 1 || len(global.messages) != 1 {
+		t.Errorf("connection logged %q and package %q, want one message from the package", connLogger.messages, global.messages)
+	}
+}
+
+func TestParserLogger(t *testing.T) {
+	global := &testLogger{}
+	SetLogger(global)
+	defer SetLogger(nil)
+
+	parserLogger := &testLogger{}
+	parser := NewParserWithLogger(parserLogger)
+	// One column short of the table map
+	parser.RegisterTable("test", "t", []Column{{Name: "id"}})
+	if _, err := parser.ParseEvent(testTableMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(parserLogger.messages) != 1 || !strings.Contains(parserLogger.messages[0], "test.t") || len(global.messages) != 0 {
+		t.Errorf("parser logged %q and package %q, want the ignored columns from the parser", parserLogger.messages, global.messages)
+	}
+
+	// Without its own, the parser logs to the package's
+	parser = NewParser()
+	parser.RegisterTable("test", "t", []Column{{Name: "id"}})
+	if _, err := parser.ParseEvent(testTableMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(global.messages) != 1 {
+		t.Errorf("package logged %q, want the ignored columns", global.messages)
+	}
+
+	// The package's logger can be replaced while parsers use it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetLogger(nil)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		NewParser().ParseEvent(makeFormatDescription("8.0.32-log", BINLOG_CHECKSUM_ALG_OFF))
+	}
+	<-done
+}
+
+func TestReadLengthEncodedInt(t *testing.T) {
+	for _, c := range []struct {
+		data []byte
+		num uint64
+		isNull bool
+		consumed int // bytes read, -1 for an error
+	}{
+		{[]byte{0, 9}, 0, false, 1},
+		{[]byte{0xfa, 9}, 250, false, 1},
+		{[]byte{0xfb, 9}, 0, true, 1},
+		{[]byte{0xfc, 0x34, 0x12, 9}, 0x1234, false, 3},
+		{[]byte{0xfd, 0x56, 0x34, 0x12, 9}, 0x123456, false, 4},
+		{[]byte{0xfe, 8, 7, 6, 5, 4, 3, 2, 1, 9}, 0x0102030405060708, false, 9},
+		{[]byte{0xff, 9}, 0, false, -1},
+		{[]byte{0xfc, 0x34}, 0, false, -1},
+		{[]byte{0xfe, 8, 7, 6, 5, 4, 3, 2}, 0, false, -1},
+		{[]byte{}, 0, false, -1},
+	} {
+		buf := bytes.NewBuffer(c.data)
+		num, isNull, err := readLengthEncodedInt(buf)
+		if c.consumed < 0 {
+			if err == nil {
+				t.Errorf("% x: decoded %d, want an error", c.data, num)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("% x: %v", c.data, err)
+		} else if num != c.num || isNull != c.isNull || len(c.data) - buf.Len() != c.consumed {
+			t.Errorf("% x: %d null %v, %d bytes read, want %d null %v, %d bytes", c.data, num, isNull, len(c.data) - buf.Len(), c.num, c.isNull, c.consumed)
+		}
+	}
+}